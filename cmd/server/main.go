@@ -3,19 +3,31 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"auth-user-service/internal/admin"
+	"auth-user-service/internal/apierror"
 	"auth-user-service/internal/auth"
 	"auth-user-service/internal/config"
 	"auth-user-service/internal/database"
+	"auth-user-service/internal/federation"
+	"auth-user-service/internal/idempotency"
+	"auth-user-service/internal/keyring"
+	"auth-user-service/internal/mailer"
+	"auth-user-service/internal/oidc"
 	"auth-user-service/internal/order"
+	"auth-user-service/internal/password"
+	"auth-user-service/internal/rbac"
 	"auth-user-service/internal/redis"
 	"auth-user-service/internal/user"
+	"auth-user-service/internal/validator"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -23,14 +35,33 @@ import (
 	"github.com/go-chi/httprate"
 )
 
+// writeTimeout время на ответ. Повтор запроса с ключом идемпотентности
+// должен дождаться первого запроса раньше, чем оно истечет
+const writeTimeout = 15 * time.Second
+
 func main() {
 	// Загружаем конфигурацию
 	cfg := config.Load()
 
-	if cfg.Environment == "production" && cfg.JWT.Secret == "" {
-		log.Fatal("JWT_SECRET must be set in production")
+	if cfg.Environment == "production" && cfg.JWT.Secret == "" && len(cfg.JWT.SigningKeys) == 0 {
+		log.Fatal("JWT_SECRET or JWT_SIGNING_KEYS must be set in production")
+	}
+
+	if cfg.Idempotency.LockTimeout >= writeTimeout {
+		log.Fatalf("❌ IDEMPOTENCY_LOCK_TIMEOUT must be less than the %s write timeout", writeTimeout)
 	}
 
+	keys, err := keyring.Load(keyring.Config{
+		Secret:          cfg.JWT.Secret,
+		SecretExpiresAt: cfg.JWT.SecretExpiresAt,
+		Keys:            cfg.JWT.SigningKeys,
+		ActiveKeyID:     cfg.JWT.ActiveKeyID,
+	})
+	if err != nil {
+		log.Fatalf("❌ Failed to load JWT signing keys: %v", err)
+	}
+	log.Printf("🔑 Signing tokens with key %q (%s)", keys.Active().ID, keys.Active().Algorithm())
+
 	// Подключаемся к PostgreSQL
 	dbConfig := database.DatabaseConfig{
 		Host:     cfg.Database.Host,
@@ -71,27 +102,153 @@ func main() {
 	}
 
 	// Инициализация сервисов
+	// Отозванные токены, challenge WebAuthn и ключи идемпотентности храним в Redis, без него — в PostgreSQL
+	// Счетчики неудачных входов — в Redis, без него — в памяти процесса
+	var revocations auth.RevocationStore
+	var challenges auth.ChallengeStore
+	var attempts auth.AttemptStore
+	var idempotencyKeys idempotency.Store
+	if redisClient != nil {
+		revocations = auth.NewRedisRevocationStore(redisClient, cfg.JWT.AccessTokenTTL)
+		challenges = auth.NewRedisChallengeStore(redisClient)
+		attempts = auth.NewRedisAttemptStore(redisClient)
+		idempotencyKeys = idempotency.NewRedisStore(redisClient)
+	} else {
+		revocations = auth.NewPostgresRevocationStore(db)
+		challenges = auth.NewPostgresChallengeStore(db)
+		attempts = auth.NewMemoryAttemptStore()
+		idempotencyKeys = idempotency.NewPostgresStore(db)
+	}
+	idempotencyMiddleware := idempotency.NewMiddleware(idempotencyKeys, cfg.Idempotency.TTL, cfg.Idempotency.LockTimeout)
+
+	mail, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("❌ Failed to configure mailer: %v", err)
+	}
+
+	passwordPolicy, err := validator.NewPasswordPolicy(
+		cfg.Password.MinLength, cfg.Password.MaxLength, cfg.Password.MinClasses, cfg.Password.BannedFile)
+	if err != nil {
+		log.Fatalf("❌ Failed to configure password policy: %v", err)
+	}
+
+	passwordHasher, err := password.NewHasher(password.Config{
+		Algorithm:  cfg.Password.HashAlgorithm,
+		BcryptCost: cfg.Password.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      uint32(cfg.Password.Argon2Memory),
+			Iterations:  uint32(cfg.Password.Argon2Iterations),
+			Parallelism: uint8(cfg.Password.Argon2Parallelism),
+		},
+		MaxConcurrent: cfg.Password.HashConcurrency,
+	})
+	if err != nil {
+		log.Fatalf("❌ Failed to configure password hashing: %v", err)
+	}
+
+	breachedPasswords, warnBreached, err := newBreachedPasswords(cfg.Password)
+	if err != nil {
+		log.Fatalf("❌ Failed to configure breached password check: %v", err)
+	}
+	if breachedPasswords != nil {
+		defer breachedPasswords.Close()
+	}
+
 	authRepo := auth.NewRepository(db)
-	authService := auth.NewService(authRepo, cfg.JWT.Secret)
+	authService := auth.NewService(authRepo, revocations, challenges, attempts, mail, keys, auth.Config{
+		AccessTokenTTL:  cfg.JWT.AccessTokenTTL,
+		RefreshTokenTTL: cfg.JWT.RefreshTokenTTL,
+		MFAIssuer:       cfg.MFA.Issuer,
+		WebAuthn: auth.WebAuthnConfig{
+			RPID:    cfg.WebAuthn.RPID,
+			RPName:  cfg.WebAuthn.RPName,
+			Origins: cfg.WebAuthn.Origins,
+		},
+		PasswordResetURL: cfg.Password.ResetURL,
+		PasswordResetTTL: cfg.Password.ResetTTL,
+		PasswordPolicy:   passwordPolicy,
+		PasswordHasher:   passwordHasher,
+
+		MagicLinkURL:          cfg.MagicLink.URL,
+		MagicLinkTTL:          cfg.MagicLink.TTL,
+		MagicLinkAutoRegister: cfg.MagicLink.AutoRegister,
+
+		BreachedPasswords:     breachedPasswords,
+		WarnBreachedPasswords: warnBreached,
+
+		EmailVerificationURL:         cfg.EmailVerify.URL,
+		EmailVerificationTTL:         cfg.EmailVerify.TTL,
+		VerificationResendInterval:   cfg.EmailVerify.ResendInterval,
+		RequireVerifiedEmailForLogin: cfg.EmailVerify.RequiredFor("login"),
+		EmailChangeURL:               cfg.EmailVerify.ChangeURL,
+
+		AccountLockout: auth.LockoutPolicy{
+			FreeAttempts:    cfg.Lockout.FreeAttempts,
+			BaseDelay:       cfg.Lockout.BaseDelay,
+			MaxDelay:        cfg.Lockout.MaxDelay,
+			MaxAttempts:     cfg.Lockout.MaxAttempts,
+			LockoutDuration: cfg.Lockout.Duration,
+		},
+		IPLockout: auth.LockoutPolicy{
+			FreeAttempts:    cfg.Lockout.IPFreeAttempts,
+			BaseDelay:       cfg.Lockout.BaseDelay,
+			MaxDelay:        cfg.Lockout.MaxDelay,
+			MaxAttempts:     cfg.Lockout.IPMaxAttempts,
+			LockoutDuration: cfg.Lockout.Duration,
+		},
+	})
 	authHandler := auth.NewHandler(authService)
 
 	userRepo := user.NewRepository(db)
-	userService := user.NewService(userRepo, redisClient)
+	userService := user.NewService(userRepo, redisClient, authService)
 	userHandler := user.NewHandler(userService)
 
 	orderRepo := order.NewRepository(db)
 	orderService := order.NewService(orderRepo)
 	orderHandler := order.NewHandler(orderService)
 
+	rbacRepo := rbac.NewRepository(db)
+	rbacService := rbac.NewService(rbacRepo, revocations)
+	rbacHandler := rbac.NewHandler(rbacService)
+
+	adminRepo := admin.NewRepository(db)
+	adminService := admin.NewService(adminRepo, authService, userService, orderService)
+	adminHandler := admin.NewHandler(adminService)
+
+	if err := rbacService.BootstrapAdmins(cfg.AdminEmails); err != nil {
+		log.Fatalf("❌ Failed to bootstrap admins: %v", err)
+	}
+
+	oidcRepo := oidc.NewRepository(db)
+	oidcService := oidc.NewService(oidcRepo, authService, userRepo, keys, oidc.Config{
+		Issuer:      cfg.OIDC.Issuer,
+		AuthCodeTTL: cfg.OIDC.AuthCodeTTL,
+	})
+	oidcHandler := oidc.NewHandler(oidcService, authService)
+
+	var providers []federation.ProviderConfig
+	for _, p := range cfg.Federation.Providers {
+		providers = append(providers, federation.ProviderConfig(p))
+	}
+	federationRepo := federation.NewRepository(db)
+	federationService, err := federation.NewService(federationRepo, authService, federation.Config{
+		CallbackBaseURL: cfg.Federation.CallbackBaseURL,
+		Providers:       providers,
+	})
+	if err != nil {
+		log.Fatalf("❌ Failed to configure identity providers: %v", err)
+	}
+	federationHandler := federation.NewHandler(federationService, strings.HasPrefix(cfg.Federation.CallbackBaseURL, "https://"))
+
 	// Создаем роутер
-	r := setupRouter(authHandler, userHandler, orderHandler, cfg, redisClient)
+	r := setupRouter(authHandler, userHandler, orderHandler, rbacHandler, adminHandler, oidcHandler, federationHandler, idempotencyMiddleware, cfg, redisClient)
 
 	// Настраиваем сервер
 	server := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
 		Handler:      r,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -120,15 +277,59 @@ func main() {
 	log.Println("✅ Server exited")
 }
 
-func setupRouter(authHandler *auth.Handler, userHandler *user.Handler, orderHandler *order.Handler, cfg *config.Config, redisClient *redis.Client) *chi.Mux {
+func newMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST is required for the smtp mail driver")
+		}
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}), nil
+	case "file":
+		return mailer.NewFileMailer(cfg.Dir, cfg.From)
+	case "log":
+		return mailer.NewLogMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// newBreachedPasswords открывает базу утекших паролей, если она настроена
+func newBreachedPasswords(cfg config.PasswordConfig) (*validator.BreachedPasswords, bool, error) {
+	if cfg.BreachedFile == "" {
+		return nil, false, nil
+	}
+
+	var warn bool
+	switch cfg.BreachedMode {
+	case "reject":
+	case "warn":
+		warn = true
+	default:
+		return nil, false, fmt.Errorf("unknown breached password mode %q", cfg.BreachedMode)
+	}
+
+	breached, err := validator.OpenBreachedPasswords(cfg.BreachedFile)
+	if err != nil {
+		return nil, false, err
+	}
+	return breached, warn, nil
+}
+
+func setupRouter(authHandler *auth.Handler, userHandler *user.Handler, orderHandler *order.Handler, rbacHandler *rbac.Handler, adminHandler *admin.Handler, oidcHandler *oidc.Handler, federationHandler *federation.Handler, idempotencyMiddleware *idempotency.Middleware, cfg *config.Config, redisClient *redis.Client) *chi.Mux {
 	r := chi.NewRouter()
 
 	// CORS middleware
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   cfg.CORS.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With", "Origin", "Cache-Control"},
-		ExposedHeaders:   []string{"Link", "Content-Length", "X-Total-Count"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With", "Origin", "Cache-Control", idempotency.HeaderKey},
+		ExposedHeaders:   []string{"Link", "Content-Length", "X-Total-Count", idempotency.HeaderReplayed},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -143,25 +344,135 @@ func setupRouter(authHandler *auth.Handler, userHandler *user.Handler, orderHand
 
 	// Rate limiting для auth эндпоинтов
 	r.Group(func(r chi.Router) {
-		r.Use(httprate.LimitByIP(10, 1*time.Minute))
+		r.Use(limitByIP(10, 1*time.Minute))
 		r.Post("/auth/register", authHandler.Register)
 		r.Post("/auth/login", authHandler.Login)
+		r.Post("/auth/login/mfa", authHandler.LoginMFA)
+		r.Post("/auth/passkey/login/begin", authHandler.BeginPasskeyLogin)
+		r.Post("/auth/passkey/login/finish", authHandler.FinishPasskeyLogin)
+		r.Post("/auth/password/forgot", authHandler.ForgotPassword)
+		r.Post("/auth/password/reset", authHandler.ResetPassword)
+		r.Post("/auth/verify-email/resend", authHandler.ResendVerification)
+		r.Post("/auth/magic-link", authHandler.RequestMagicLink)
+		r.Get("/auth/magic-link/verify", authHandler.ConsumeMagicLink)
+		r.Post("/auth/magic-link/verify", authHandler.ConsumeMagicLink)
+	})
+
+	// Подтверждение email и смены email по ссылке из письма
+	r.Get("/auth/verify-email", authHandler.VerifyEmail)
+	r.Post("/auth/verify-email", authHandler.VerifyEmail)
+	r.Get("/auth/email/confirm", userHandler.ConfirmEmailChange)
+	r.Post("/auth/email/confirm", userHandler.ConfirmEmailChange)
+
+	// Refresh принимает refresh токен в теле, а не access токен
+	r.With(limitByIP(30, 1*time.Minute)).Post("/auth/refresh", authHandler.Refresh)
+
+	// Открытые ключи для локальной проверки токенов
+	r.Get("/.well-known/jwks.json", authHandler.JWKS)
+
+	// OpenID Connect провайдер (authorization code + PKCE)
+	r.Get("/.well-known/openid-configuration", oidcHandler.Discovery)
+	r.Get("/authorize", oidcHandler.Authorize)
+	r.With(limitByIP(10, 1*time.Minute)).Post("/authorize", oidcHandler.Authorize)
+	r.With(limitByIP(30, 1*time.Minute)).Post("/token", oidcHandler.Token)
+	r.With(authHandler.AuthMiddleware).Get("/userinfo", oidcHandler.UserInfo)
+	r.With(authHandler.AuthMiddleware).Post("/userinfo", oidcHandler.UserInfo)
+
+	// Вход через внешних провайдеров
+	r.Get("/auth/providers", federationHandler.ListProviders)
+	r.Group(func(r chi.Router) {
+		r.Use(limitByIP(10, 1*time.Minute))
+		r.Get("/auth/providers/{provider}/login", federationHandler.Login)
+		r.Get("/auth/providers/{provider}/callback", federationHandler.Callback)
 	})
 
 	// Protected auth routes
-	r.With(authHandler.AuthMiddleware).Post("/auth/refresh", authHandler.Refresh)
-	r.With(authHandler.AuthMiddleware).Post("/auth/logout", authHandler.Logout)
+	r.With(authHandler.AuthMiddleware, authHandler.RequireToken).Post("/auth/logout", authHandler.Logout)
+	r.With(authHandler.AuthMiddleware, authHandler.RequireToken).Post("/auth/logout/all", authHandler.LogoutAll)
 
 	// Protected API routes
 	r.Route("/api", func(r chi.Router) {
-		r.Use(authHandler.AuthMiddleware)
+		r.Use(authHandler.AuthMiddleware, authHandler.RejectClientTokens)
 
 		r.Get("/user/profile", userHandler.GetProfile)
 		r.Put("/user/profile", userHandler.UpdateProfile)
 
 		r.Get("/orders", orderHandler.GetUserOrders)
 		r.Get("/orders/{id}", orderHandler.GetOrder)
-		r.Post("/orders", orderHandler.CreateOrder)
+		r.Get("/orders/{id}/history", orderHandler.GetOrderHistory)
+		r.Patch("/orders/{id}/cancel", orderHandler.CancelOrder)
+		if cfg.EmailVerify.RequiredFor("orders") {
+			r.With(authHandler.RequireVerifiedEmail, idempotencyMiddleware.Handler).Post("/orders", orderHandler.CreateOrder)
+		} else {
+			r.With(idempotencyMiddleware.Handler).Post("/orders", orderHandler.CreateOrder)
+		}
+
+		// Управление входом доступно только с access токеном, не с API ключом
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequireToken)
+
+			r.Put("/user/password", userHandler.ChangePassword)
+			r.Post("/user/email", userHandler.ChangeEmail)
+
+			r.Post("/mfa/totp/enroll", authHandler.EnrollTOTP)
+			r.Post("/mfa/totp/confirm", authHandler.ConfirmTOTP)
+			r.Post("/mfa/disable", authHandler.DisableMFA)
+
+			r.Post("/passkeys/register/begin", authHandler.BeginPasskeyRegistration)
+			r.Post("/passkeys/register/finish", authHandler.FinishPasskeyRegistration)
+			r.Get("/passkeys", authHandler.ListPasskeys)
+			r.Delete("/passkeys/{id}", authHandler.DeletePasskey)
+
+			r.Get("/sessions", authHandler.ListSessions)
+			r.Delete("/sessions/{id}", authHandler.RevokeSession)
+
+			r.Get("/keys", authHandler.ListAPIKeys)
+			r.Post("/keys", authHandler.CreateAPIKey)
+			r.Delete("/keys/{id}", authHandler.RevokeAPIKey)
+		})
+	})
+
+	// Administration
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(authHandler.AuthMiddleware)
+
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequirePermission("lockouts:manage"))
+			r.Get("/lockouts", authHandler.LockoutStatus)
+			r.Delete("/lockouts", authHandler.Unlock)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequirePermission("users:read"))
+			r.Get("/users", adminHandler.ListUsers)
+			r.Get("/users/{id}", adminHandler.GetUser)
+			r.Get("/users/{id}/orders", adminHandler.GetUserOrders)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequirePermission("users:manage"))
+			r.Post("/users/{id}/disable", adminHandler.DisableUser)
+			r.Post("/users/{id}/enable", adminHandler.EnableUser)
+			r.Post("/users/{id}/password-reset", adminHandler.ForcePasswordReset)
+			r.Delete("/users/{id}/sessions", adminHandler.RevokeSessions)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequirePermission("orders:manage"))
+			r.Patch("/orders/{id}/status", adminHandler.TransitionOrder)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequirePermission("roles:read"))
+			r.Get("/roles", rbacHandler.ListRoles)
+			r.Get("/users/{id}/roles", rbacHandler.ListUserRoles)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authHandler.RequirePermission("roles:manage"))
+			r.Post("/users/{id}/roles", rbacHandler.GrantRole)
+			r.Delete("/users/{id}/roles/{role}", rbacHandler.RevokeRole)
+		})
 	})
 
 	// Health check - УПРОЩЕННАЯ РАБОЧАЯ ВЕРСИЯ
@@ -218,3 +529,17 @@ func setupRouter(authHandler *auth.Handler, userHandler *user.Handler, orderHand
 
 	return r
 }
+
+// errRateLimited ответ лимитера запросов, в том же формате, что и остальные ошибки
+var errRateLimited = apierror.TooManyRequests("rate_limited", "Too many requests, please try again later")
+
+// limitByIP ограничивает число запросов с одного IP за окно. Retry-After
+// ставит httprate
+func limitByIP(requests int, window time.Duration) func(http.Handler) http.Handler {
+	return httprate.Limit(requests, window,
+		httprate.WithKeyFuncs(httprate.KeyByIP),
+		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+			apierror.Write(w, errRateLimited)
+		}),
+	)
+}
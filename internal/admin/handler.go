@@ -0,0 +1,218 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/order"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ListUsers список пользователей: ?q=&status=active|disabled&limit=&offset=
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := UserFilter{
+		Query:  query.Get("q"),
+		Status: query.Get("status"),
+	}
+
+	if filter.Status != "" && filter.Status != "active" && filter.Status != "disabled" {
+		h.writeError(w, apierror.Validation("validation_failed", "Validation failed",
+			apierror.FieldError{Field: "status", Code: "invalid_status", Message: "must be active or disabled"}))
+		return
+	}
+
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil {
+			h.writeError(w, invalidNumber("limit"))
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil {
+			h.writeError(w, invalidNumber("offset"))
+			return
+		}
+	}
+
+	users, err := h.service.ListUsers(filter)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, users, http.StatusOK)
+}
+
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.GetUser(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, user, http.StatusOK)
+}
+
+func (h *Handler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := order.ParseOrderFilter(r.URL.Query())
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	page, err := h.service.ListUserOrders(userID, filter)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	order.WritePageHeaders(w, r, page)
+	h.writeJSON(w, page.Orders, http.StatusOK)
+}
+
+// TransitionOrder переводит заказ в другой статус
+func (h *Handler) TransitionOrder(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	orderID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req order.TransitionOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	updated, err := h.service.TransitionOrder(orderID, adminID, req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, updated, http.StatusOK)
+}
+
+func (h *Handler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	userID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DisableUser(userID, adminID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string]string{"message": "User disabled"}, http.StatusOK)
+}
+
+func (h *Handler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.EnableUser(userID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string]string{"message": "User enabled"}, http.StatusOK)
+}
+
+func (h *Handler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.ForcePasswordReset(userID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string]string{"message": "Password reset, a link to choose a new one has been sent"}, http.StatusOK)
+}
+
+func (h *Handler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeSessions(userID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Вспомогательные методы
+// pathID читает числовой {id} из пути запроса
+func (h *Handler) pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return 0, false
+	}
+	return id, true
+}
+
+func invalidNumber(field string) error {
+	return apierror.Validation("validation_failed", "Validation failed",
+		apierror.FieldError{Field: field, Code: "invalid_number", Message: "must be an integer"})
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// writeError отвечает ошибкой в формате application/problem+json
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	apierror.Write(w, err)
+}
@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Repository interface {
+	ListUsers(filter UserFilter) ([]User, int, error)
+	GetUser(userID int) (*User, error)
+	SetDisabled(userID int, disabled bool) (bool, error)
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return &repository{db: db}
+}
+
+// User учетная запись в том виде, в каком ее видит администратор
+type User struct {
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	FirstName       string     `json:"first_name,omitempty"`
+	LastName        string     `json:"last_name,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// UserFilter поиск и постраничный вывод пользователей. Query ищется по
+// началу email, имени, фамилии или "имя фамилия"
+type UserFilter struct {
+	Query  string
+	Status string // "active", "disabled" или пусто для всех
+	Limit  int
+	Offset int
+}
+
+const userColumns = `id, email, COALESCE(first_name, ''), COALESCE(last_name, ''),
+	email_verified_at, disabled_at, created_at, updated_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID, &user.Email, &user.FirstName, &user.LastName,
+		&user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers возвращает страницу пользователей и их общее число по фильтру
+func (r *repository) ListUsers(filter UserFilter) ([]User, int, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Query != "" {
+		args = append(args, escapeLike(filter.Query)+"%")
+		n := len(args)
+		conditions = append(conditions, fmt.Sprintf(
+			`(email ILIKE $%d OR first_name ILIKE $%d OR last_name ILIKE $%d
+			  OR first_name || ' ' || last_name ILIKE $%d)`, n, n, n, n))
+	}
+	switch filter.Status {
+	case "active":
+		conditions = append(conditions, "disabled_at IS NULL")
+	case "disabled":
+		conditions = append(conditions, "disabled_at IS NOT NULL")
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	rows, err := r.db.Query(
+		fmt.Sprintf("SELECT %s FROM users%s ORDER BY id LIMIT $%d OFFSET $%d",
+			userColumns, where, len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, total, rows.Err()
+}
+
+func (r *repository) GetUser(userID int) (*User, error) {
+	user, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// SetDisabled отключает или включает учетную запись. Возвращает false,
+// если пользователь не найден
+func (r *repository) SetDisabled(userID int, disabled bool) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE users
+		 SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, updated_at = NOW()
+		 WHERE id = $1`,
+		userID, disabled,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
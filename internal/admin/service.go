@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/auth"
+	"auth-user-service/internal/order"
+	"auth-user-service/internal/user"
+)
+
+var (
+	ErrUserNotFound = apierror.NotFound("user_not_found", "User not found")
+	// ErrSelfAction администратор не может отключить собственную учетную запись
+	ErrSelfAction = apierror.Conflict("self_action", "Cannot perform this action on your own account")
+)
+
+// UserDetails пользователь вместе с профилем
+type UserDetails struct {
+	User
+	Profile *user.Profile `json:"profile,omitempty"`
+}
+
+// UserList страница списка пользователей
+type UserList struct {
+	Users  []User `json:"users"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+type Service interface {
+	ListUsers(filter UserFilter) (*UserList, error)
+	GetUser(userID int) (*UserDetails, error)
+	ListUserOrders(userID int, filter order.OrderFilter) (*order.OrderPage, error)
+	TransitionOrder(orderID, adminID int, req order.TransitionOrderRequest) (*order.Order, error)
+	DisableUser(userID, adminID int) error
+	EnableUser(userID int) error
+	ForcePasswordReset(userID int) error
+	RevokeSessions(userID int) error
+}
+
+type service struct {
+	repo         Repository
+	authService  auth.Service
+	userService  user.Service
+	orderService order.Service
+}
+
+func NewService(repo Repository, authService auth.Service, userService user.Service, orderService order.Service) Service {
+	return &service{
+		repo:         repo,
+		authService:  authService,
+		userService:  userService,
+		orderService: orderService,
+	}
+}
+
+func (s *service) ListUsers(filter UserFilter) (*UserList, error) {
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	users, total, err := s.repo.ListUsers(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return &UserList{Users: users, Total: total, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
+
+func (s *service) GetUser(userID int) (*UserDetails, error) {
+	u, err := s.repo.GetUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	profile, err := s.userService.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserDetails{User: *u, Profile: profile}, nil
+}
+
+func (s *service) ListUserOrders(userID int, filter order.OrderFilter) (*order.OrderPage, error) {
+	if err := s.requireUser(userID); err != nil {
+		return nil, err
+	}
+	return s.orderService.ListUserOrders(userID, filter)
+}
+
+// TransitionOrder меняет статус заказа. Допустимость перехода и
+// конкурентные изменения проверяет сервис заказов
+func (s *service) TransitionOrder(orderID, adminID int, req order.TransitionOrderRequest) (*order.Order, error) {
+	return s.orderService.TransitionOrder(orderID, req.Status, req.UpdatedAt, adminID, req.Reason)
+}
+
+// DisableUser отключает учетную запись и отзывает все ее токены: уже
+// выданные access токены отклоняет AuthMiddleware, новые не выдаются, пока
+// учетная запись не будет включена снова
+func (s *service) DisableUser(userID, adminID int) error {
+	if userID == adminID {
+		return ErrSelfAction
+	}
+
+	found, err := s.repo.SetDisabled(userID, true)
+	if err != nil {
+		return fmt.Errorf("failed to disable user: %w", err)
+	}
+	if !found {
+		return ErrUserNotFound
+	}
+
+	return s.authService.LogoutAll(userID, time.Now())
+}
+
+func (s *service) EnableUser(userID int) error {
+	found, err := s.repo.SetDisabled(userID, false)
+	if err != nil {
+		return fmt.Errorf("failed to enable user: %w", err)
+	}
+	if !found {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *service) ForcePasswordReset(userID int) error {
+	err := s.authService.ForcePasswordReset(userID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (s *service) RevokeSessions(userID int) error {
+	if err := s.requireUser(userID); err != nil {
+		return err
+	}
+	return s.authService.LogoutAll(userID, time.Now())
+}
+
+func (s *service) requireUser(userID int) error {
+	u, err := s.repo.GetUser(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if u == nil {
+		return ErrUserNotFound
+	}
+	return nil
+}
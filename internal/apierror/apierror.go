@@ -0,0 +1,152 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Kind категория ошибки, по ней выбирается HTTP статус ответа
+type Kind string
+
+const (
+	KindValidation      Kind = "validation"
+	KindUnauthorized    Kind = "unauthorized"
+	KindForbidden       Kind = "forbidden"
+	KindNotFound        Kind = "not_found"
+	KindConflict        Kind = "conflict"
+	KindUnprocessable   Kind = "unprocessable"
+	KindTooManyRequests Kind = "too_many_requests"
+	KindUpstream        Kind = "upstream"
+	KindInternal        Kind = "internal"
+)
+
+// Status HTTP статус для категории
+func (k Kind) Status() int {
+	switch k {
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindUnprocessable:
+		return http.StatusUnprocessableEntity
+	case KindTooManyRequests:
+		return http.StatusTooManyRequests
+	case KindUpstream:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// FieldError ошибка в конкретном поле запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error доменная ошибка. Code стабилен и предназначен для клиентов,
+// Message попадает в ответ как detail и может меняться
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s %s", e.Message, e.Fields[0].Field, e.Fields[0].Message)
+}
+
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Validation ошибка запроса. fields уточняют, какие поля неверны
+func Validation(code, message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message, Fields: fields}
+}
+
+func Unauthorized(code, message string) *Error {
+	return New(KindUnauthorized, code, message)
+}
+
+func Forbidden(code, message string) *Error {
+	return New(KindForbidden, code, message)
+}
+
+func NotFound(code, message string) *Error {
+	return New(KindNotFound, code, message)
+}
+
+func Conflict(code, message string) *Error {
+	return New(KindConflict, code, message)
+}
+
+func TooManyRequests(code, message string) *Error {
+	return New(KindTooManyRequests, code, message)
+}
+
+// Internal внутренняя ошибка. Причину нужно записать в лог отдельно:
+// клиенту она не показывается
+func Internal(message string) *Error {
+	return New(KindInternal, "internal_error", message)
+}
+
+// Общие ошибки, которые возвращают все обработчики
+var (
+	ErrInvalidJSON     = Validation("invalid_json", "Request body is not valid JSON")
+	ErrInvalidID       = Validation("invalid_id", "Invalid ID in the request path")
+	ErrUnauthenticated = Unauthorized("unauthenticated", "User not authenticated")
+	ErrInternal        = Internal("Internal server error")
+)
+
+// DecodeError ошибка разбора тела запроса. Ошибки валидации, которые вернул
+// UnmarshalJSON полей, отдаются клиенту как есть, остальные заменяются на
+// ErrInvalidJSON
+func DecodeError(err error) error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return ErrInvalidJSON
+}
+
+// MissingField ошибка validation_failed для одного обязательного поля
+func MissingField(field string) *Error {
+	return Validation("validation_failed", "Validation failed",
+		FieldError{Field: field, Code: "required", Message: "is required"})
+}
+
+// Fields собирает ошибки полей при валидации запроса
+type Fields []FieldError
+
+// Add добавляет ошибку поля
+func (f *Fields) Add(field, code, message string) {
+	*f = append(*f, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Required добавляет ошибку, если значение пустое
+func (f *Fields) Required(field, value string) {
+	if value == "" {
+		f.Add(field, "required", "is required")
+	}
+}
+
+// Err возвращает ошибку validation_failed со всеми полями или nil
+func (f Fields) Err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return Validation("validation_failed", "Validation failed", f...)
+}
@@ -0,0 +1,54 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// ContentType тип ответа об ошибке по RFC 7807
+const ContentType = "application/problem+json"
+
+// Problem тело ответа об ошибке по RFC 7807. type всегда about:blank, а
+// вместо собственных URI типов ошибка определяется расширением code
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem формирует ответ для ошибки. Ошибка, в цепочке которой нет
+// *Error, считается внутренней: она записывается в лог, а клиент получает
+// только общий текст
+func NewProblem(err error) Problem {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		log.Printf("Unhandled error: %v", err)
+		apiErr = ErrInternal
+	}
+
+	status := apiErr.Kind.Status()
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: apiErr.Message,
+		Code:   apiErr.Code,
+		Errors: apiErr.Fields,
+	}
+}
+
+// Write отвечает ошибкой в формате application/problem+json
+func Write(w http.ResponseWriter, err error) {
+	problem := NewProblem(err)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Printf("Error encoding problem response: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/mailer"
+	"auth-user-service/internal/validator"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidEmailChangeLink = apierror.Validation("invalid_email_change_link", "invalid or expired email change link")
+	ErrSameEmail              = apierror.Validation("same_email", "new email is the same as the current one")
+)
+
+// ChangePassword меняет пароль после проверки текущего и завершает все
+// остальные сессии пользователя. Сессия, из которой сделан запрос, остается.
+// Неверный текущий пароль считается неудачной попыткой входа в учетную запись
+func (s *service) ChangePassword(userID int, currentPassword, newPassword, currentSessionID string) ([]validator.PasswordViolation, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	ctx := context.Background()
+	attempt, err := s.beginAttempt(ctx, []attemptCounter{
+		{key: accountAttemptKey(user.Email), policy: s.accountLockout, resetOnSuccess: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.hasher.Verify(currentPassword, user.PasswordHash); err != nil {
+		attempt.fail(ctx)
+		return nil, ErrInvalidCredentials
+	}
+	attempt.succeed(ctx)
+
+	warnings, err := s.validateNewPassword(newPassword, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := s.repo.UpdatePassword(userID, hashedPassword); err != nil {
+		return nil, fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := s.revokeOtherSessions(userID, currentSessionID); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// rehashPassword пересчитывает хэш пароля после успешного входа, если он
+// получен другим алгоритмом или с более слабыми параметрами, чем текущие.
+// Ошибка не мешает входу: хэш обновится при следующем
+func (s *service) rehashPassword(user *User, password string) {
+	if !s.hasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Printf("⚠️ Failed to rehash password for user %d: %v", user.ID, err)
+		return
+	}
+	if err := s.repo.RehashPassword(user.ID, user.PasswordHash, hashedPassword); err != nil {
+		log.Printf("⚠️ Failed to rehash password for user %d: %v", user.ID, err)
+		return
+	}
+	user.PasswordHash = hashedPassword
+}
+
+// validateNewPassword проверяет новый пароль по политике и по базе утекших
+// паролей. Утекший пароль отклоняется, а в режиме предупреждения принимается
+// и возвращается нарушение, которое стоит показать пользователю
+func (s *service) validateNewPassword(password, email string) ([]validator.PasswordViolation, error) {
+	if err := s.passwordPolicy.Validate(password, email); err != nil {
+		return nil, err
+	}
+	if s.breached == nil {
+		return nil, nil
+	}
+
+	count, err := s.breached.Count(password)
+	if err != nil {
+		// Поврежденная база не должна мешать сменить пароль
+		log.Printf("⚠️ Failed to check breached passwords: %v", err)
+		return nil, nil
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	violations := []validator.PasswordViolation{{
+		Rule:    "breached_password",
+		Message: "has appeared in a data breach and should not be used",
+	}}
+	if s.warnBreached {
+		return violations, nil
+	}
+	return nil, &validator.PasswordPolicyError{Violations: violations}
+}
+
+// RequestEmailChange проверяет текущий пароль и отправляет на новый адрес
+// ссылку для подтверждения. Email меняется только после перехода по ней
+func (s *service) RequestEmailChange(userID int, currentPassword, newEmail string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	ctx := context.Background()
+	attempt, err := s.beginAttempt(ctx, []attemptCounter{
+		{key: accountAttemptKey(user.Email), policy: s.accountLockout, resetOnSuccess: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.hasher.Verify(currentPassword, user.PasswordHash); err != nil {
+		attempt.fail(ctx)
+		return ErrInvalidCredentials
+	}
+	attempt.succeed(ctx)
+
+	if strings.EqualFold(user.Email, newEmail) {
+		return ErrSameEmail
+	}
+
+	exists, err := s.repo.UserExists(newEmail)
+	if err != nil {
+		return fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if exists {
+		return ErrUserExists
+	}
+
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	token, err := s.keys.Sign(jwt.MapClaims{
+		"user_id":   user.ID,
+		"email":     user.Email,
+		"new_email": newEmail,
+		"jti":       tokenID,
+		"exp":       time.Now().Add(s.emailVerificationTTL).Unix(),
+		"iat":       time.Now().Unix(),
+		"type":      "change_email",
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sendInBackground(user.ID, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("To use this address for your account, open this link within %s:\n%s\n\n"+
+			"If you did not request this, you can ignore this email.\n",
+			s.emailVerificationTTL, linkWithToken(s.emailChangeURL, token)),
+	})
+
+	return nil
+}
+
+// ConfirmEmailChange меняет email по ссылке из письма и уведомляет старый
+// адрес. Ссылка действует, только пока email не менялся, поэтому
+// использовать ее повторно нельзя. Возвращает id пользователя
+func (s *service) ConfirmEmailChange(tokenString string) (int, error) {
+	token, err := s.keys.Parse(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return 0, ErrInvalidEmailChangeLink
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["type"] != "change_email" {
+		return 0, ErrInvalidEmailChangeLink
+	}
+
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, ErrInvalidEmailChangeLink
+	}
+	oldEmail, ok := claims["email"].(string)
+	if !ok {
+		return 0, ErrInvalidEmailChangeLink
+	}
+	newEmail, ok := claims["new_email"].(string)
+	if !ok {
+		return 0, ErrInvalidEmailChangeLink
+	}
+	userID := int(userIDFloat)
+
+	changed, err := s.repo.ChangeEmail(userID, oldEmail, newEmail)
+	if err != nil {
+		if errors.Is(err, ErrUserExists) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to change email: %w", err)
+	}
+	if !changed {
+		return 0, ErrInvalidEmailChangeLink
+	}
+
+	s.sendInBackground(userID, mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("The email address for your account was changed to %s.\n\n"+
+			"If you did not do this, reset your password and contact support.\n", newEmail),
+	})
+
+	return userID, nil
+}
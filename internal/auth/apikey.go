@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+)
+
+// Формат ключа: ak_<prefix>_<secret>. По prefix ключ находится в БД, сам
+// ключ проверяется по хэшу
+const (
+	apiKeyPrefix    = "ak_"
+	apiKeyLookupLen = 12 // hex символов
+)
+
+var (
+	ErrInvalidAPIKey = apierror.Unauthorized("invalid_api_key", "invalid or expired API key")
+	ErrAPIKeyScope   = apierror.Forbidden("api_key_scope", "API key scope is not granted to the user")
+	// ErrAPIKeyNotFound ключ не найден или уже отозван
+	ErrAPIKeyNotFound = apierror.NotFound("api_key_not_found", "API key not found")
+)
+
+// CreateAPIKey создает ключ. Ключу можно выдать только права, которые есть у
+// пользователя. Сам ключ возвращается один раз и больше нигде не хранится
+func (s *service) CreateAPIKey(userID int, req *CreateAPIKeyRequest) (*APIKey, string, error) {
+	_, permissions, err := s.repo.GetUserAccess(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get user roles: %w", err)
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !containsString(permissions, scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrAPIKeyScope, scope)
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	lookup := make([]byte, apiKeyLookupLen/2)
+	if _, err := rand.Read(lookup); err != nil {
+		return nil, "", fmt.Errorf("failed to generate key prefix: %w", err)
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	prefix := hex.EncodeToString(lookup)
+	plain := apiKeyPrefix + prefix + "_" + secret
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(plain),
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.repo.CreateAPIKey(key); err != nil {
+		return nil, "", fmt.Errorf("failed to save API key: %w", err)
+	}
+
+	return key, plain, nil
+}
+
+func (s *service) ListAPIKeys(userID int) ([]APIKey, error) {
+	return s.repo.ListAPIKeys(userID)
+}
+
+func (s *service) RevokeAPIKey(userID, id int) error {
+	revoked, err := s.repo.RevokeAPIKey(userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// ValidateAPIKey проверяет ключ и возвращает claims, как у access токена.
+// Права ключа ограничены текущими правами пользователя: если роль снята,
+// ключ ее тоже теряет
+func (s *service) ValidateAPIKey(plain string) (*Claims, error) {
+	rest, ok := strings.CutPrefix(plain, apiKeyPrefix)
+	if !ok || len(rest) <= apiKeyLookupLen || rest[apiKeyLookupLen] != '_' {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.GetAPIKeyByPrefix(rest[:apiKeyLookupLen])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(plain))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.repo.GetUserByID(key.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+
+	_, permissions, err := s.repo.GetUserAccess(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+	var scopes []string
+	for _, scope := range key.Scopes {
+		if containsString(permissions, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if err := s.repo.TouchAPIKey(key.ID); err != nil {
+		log.Printf("⚠️ Failed to update API key usage: %v", err)
+	}
+
+	return &Claims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Scopes:   scopes,
+		APIKeyID: key.ID,
+	}, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
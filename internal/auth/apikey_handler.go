@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"auth-user-service/internal/apierror"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errAPIKeyNotAllowed      = apierror.Forbidden("api_key_not_allowed", "Not available with an API key")
+	errClientTokenNotAllowed = apierror.Forbidden("client_token_not_allowed", "Not available with a token issued to a third-party client")
+)
+
+// CreateAPIKeyResponse ответ на создание ключа. Key показывается только здесь
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	key, plain, err := h.service.CreateAPIKey(userID, &req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, CreateAPIKeyResponse{APIKey: *key, Key: plain}, http.StatusCreated)
+}
+
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	keys, err := h.service.ListAPIKeys(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, keys, http.StatusOK)
+}
+
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	if err := h.service.RevokeAPIKey(userID, id); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequireToken отклоняет запросы с API ключом и с токеном стороннего OIDC
+// клиента. Используется после AuthMiddleware для управления входом и
+// учетными данными: утекший ключ или клиент, в который вошел пользователь,
+// не должны выпускать новые ключи или менять второй фактор
+func (h *Handler) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("tokenClaims").(*Claims)
+		if !ok {
+			h.writeError(w, apierror.ErrUnauthenticated)
+			return
+		}
+		if claims.APIKeyID != 0 {
+			h.writeError(w, errAPIKeyNotAllowed)
+			return
+		}
+		if claims.ClientID != "" {
+			h.writeError(w, errClientTokenNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"auth-user-service/internal/redis"
+)
+
+// Attempts неудачные попытки входа по одному ключу (email или IP)
+type Attempts struct {
+	Count       int
+	LastFailure time.Time
+}
+
+// AttemptStore считает попытки входа. Попытка засчитывается до проверки
+// пароля, чтобы параллельные запросы не видели один и тот же счетчик.
+// Счетчик забывается через ttl после последней попытки
+type AttemptStore interface {
+	// Reserve атомарно засчитывает попытку и возвращает число попыток вместе
+	// с ней. Параллельные попытки получают разные числа
+	Reserve(ctx context.Context, key string, ttl time.Duration) (int, error)
+	// Release отменяет засчитанную попытку, которая оказалась удачной или не
+	// была проверена
+	Release(ctx context.Context, key string) error
+	// RecordFailure отмечает время неудачи засчитанной попытки
+	RecordFailure(ctx context.Context, key string, ttl time.Duration) error
+	GetAttempts(ctx context.Context, key string) (*Attempts, error)
+	ResetAttempts(ctx context.Context, key string) error
+}
+
+// Redis реализация, счетчики общие для всех экземпляров сервиса
+type redisAttemptStore struct {
+	redis RedisClient
+}
+
+func NewRedisAttemptStore(redisClient RedisClient) AttemptStore {
+	return &redisAttemptStore{redis: redisClient}
+}
+
+func (s *redisAttemptStore) Reserve(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	count, err := s.redis.Incr(ctx, "login_failures:"+key, ttl)
+	return int(count), err
+}
+
+func (s *redisAttemptStore) Release(ctx context.Context, key string) error {
+	_, err := s.redis.Decr(ctx, "login_failures:"+key)
+	return err
+}
+
+func (s *redisAttemptStore) RecordFailure(ctx context.Context, key string, ttl time.Duration) error {
+	return s.redis.Set(ctx, "login_last_failure:"+key, time.Now().UnixMilli(), ttl)
+}
+
+func (s *redisAttemptStore) GetAttempts(ctx context.Context, key string) (*Attempts, error) {
+	var count int
+	err := s.redis.Get(ctx, "login_failures:"+key, &count)
+	if errors.Is(err, redis.ErrNotFound) {
+		return &Attempts{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lastFailure int64
+	err = s.redis.Get(ctx, "login_last_failure:"+key, &lastFailure)
+	if err != nil && !errors.Is(err, redis.ErrNotFound) {
+		return nil, err
+	}
+
+	return &Attempts{Count: count, LastFailure: time.UnixMilli(lastFailure)}, nil
+}
+
+func (s *redisAttemptStore) ResetAttempts(ctx context.Context, key string) error {
+	if err := s.redis.Delete(ctx, "login_failures:"+key); err != nil {
+		return err
+	}
+	return s.redis.Delete(ctx, "login_last_failure:"+key)
+}
+
+// In-memory реализация, используется когда Redis не настроен. Счетчики
+// не разделяются между экземплярами и сбрасываются при перезапуске
+type memoryAttemptStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryAttempts
+	lastSweep time.Time
+}
+
+type memoryAttempts struct {
+	Attempts
+	expiresAt time.Time
+}
+
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{entries: make(map[string]*memoryAttempts)}
+}
+
+func (s *memoryAttemptStore) Reserve(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &memoryAttempts{}
+		s.entries[key] = entry
+	}
+	entry.Count++
+	entry.expiresAt = now.Add(ttl)
+
+	return entry.Count, nil
+}
+
+func (s *memoryAttemptStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.Count > 0 {
+		entry.Count--
+	}
+	return nil
+}
+
+func (s *memoryAttemptStore) RecordFailure(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &memoryAttempts{Attempts: Attempts{Count: 1}}
+		s.entries[key] = entry
+	}
+	entry.LastFailure = now
+	entry.expiresAt = now.Add(ttl)
+
+	return nil
+}
+
+func (s *memoryAttemptStore) GetAttempts(ctx context.Context, key string) (*Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return &Attempts{}, nil
+	}
+
+	attempts := entry.Attempts
+	return &attempts, nil
+}
+
+func (s *memoryAttemptStore) ResetAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep удаляет истекшие счетчики не чаще раза в минуту
+func (s *memoryAttemptStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
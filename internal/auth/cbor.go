@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Минимальный декодер CBOR (RFC 8949) для attestationObject и COSE ключей.
+// Поддерживаются только значения определенной длины: аутентификаторы
+// кодируют данные в канонической форме
+
+var errInvalidCBOR = errors.New("invalid cbor")
+
+// cborMaxDepth ограничивает вложенность, чтобы не разбирать заведомо
+// вредоносные данные
+const cborMaxDepth = 16
+
+// decodeCBOR разбирает одно значение и возвращает его вместе с числом
+// прочитанных байт. Целые числа возвращаются как int64, строки байт как
+// []byte, массивы как []interface{}, словари как map[interface{}]interface{}
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := &cborDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errInvalidCBOR
+	}
+
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errInvalidCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errInvalidCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errInvalidCBOR
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errInvalidCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errInvalidCBOR
+			}
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	case 6:
+		// Теги не нужны для WebAuthn, возвращаем значение без тега
+		return d.decode(depth + 1)
+	default:
+		return cborSimple(info, arg)
+	}
+}
+
+// head читает начальный байт и аргумент элемента
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, 0, errInvalidCBOR
+	}
+	initial := d.data[d.pos]
+	d.pos++
+
+	major = initial >> 5
+	info = initial & 0x1f
+
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		b, err := d.read(1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(b[0]), nil
+	case info == 25:
+		b, err := d.read(2)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.read(4)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.read(8)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, binary.BigEndian.Uint64(b), nil
+	default:
+		// Неопределенная длина и зарезервированные значения
+		return 0, 0, 0, errInvalidCBOR
+	}
+}
+
+// cborSimple разбирает простые значения и числа с плавающей точкой (major type 7)
+func cborSimple(info byte, arg uint64) (interface{}, error) {
+	switch {
+	case info == 20:
+		return false, nil
+	case info == 21:
+		return true, nil
+	case info == 22 || info == 23:
+		return nil, nil
+	case info == 25:
+		return halfToFloat(uint16(arg)), nil
+	case info == 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case info == 27:
+		return math.Float64frombits(arg), nil
+	default:
+		return nil, errInvalidCBOR
+	}
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errInvalidCBOR
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var value float64
+	switch exp {
+	case 0:
+		value = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"small uint", "17", int64(23)},
+		{"uint8", "1818", int64(24)},
+		{"uint16", "190100", int64(256)},
+		{"uint32", "1a000f4240", int64(1000000)},
+		{"uint64", "1b7fffffffffffffff", int64(math.MaxInt64)},
+		{"negative", "20", int64(-1)},
+		{"negative uint16", "390100", int64(-257)},
+		{"smallest negative", "3b7fffffffffffffff", int64(math.MinInt64)},
+		{"bytes", "43010203", []byte{1, 2, 3}},
+		{"empty bytes", "40", []byte(nil)},
+		{"text", "6449455446", "IETF"},
+		{"array", "83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"nested array", "8201820203", []interface{}{int64(1), []interface{}{int64(2), int64(3)}}},
+		{"map", "a201020326", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(-7)}},
+		{"text keys", "a1636b657963766174", map[interface{}]interface{}{"key": "vat"}},
+		{"tag", "c11a514b67b0", int64(1363896240)},
+		{"false", "f4", false},
+		{"true", "f5", true},
+		{"null", "f6", nil},
+		{"half float", "f93c00", 1.0},
+		{"half float subnormal", "f90001", 5.960464477539063e-8},
+		{"half float infinity", "f97c00", math.Inf(1)},
+		{"single float", "fa47c35000", 100000.0},
+		{"double float", "fb3ff199999999999a", 1.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := unhex(tt.data)
+			got, n, err := decodeCBOR(data)
+			if err != nil {
+				t.Fatalf("decodeCBOR(%s) error: %v", tt.data, err)
+			}
+			if n != len(data) {
+				t.Errorf("decodeCBOR(%s) read %d bytes, want %d", tt.data, n, len(data))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCBOR(%s) = %#v, want %#v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORTrailingData(t *testing.T) {
+	// Значение заканчивается раньше данных: сколько прочитано, решает вызывающий
+	got, n, err := decodeCBOR(unhex("8201020304"))
+	if err != nil {
+		t.Fatalf("decodeCBOR error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("decodeCBOR read %d bytes, want 3", n)
+	}
+	if want := []interface{}{int64(1), int64(2)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeCBOR = %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"truncated uint16", "1901"},
+		{"truncated uint64", "1b000000"},
+		{"truncated bytes", "4501020304"},
+		{"truncated text", "64494554"},
+		{"truncated array", "830102"},
+		{"map without value", "a2010203"},
+		{"truncated tag", "c1"},
+		{"truncated float", "fa47c3"},
+		{"oversized bytes", "5bffffffffffffffff00"},
+		{"oversized text", "7affffffff00"},
+		{"oversized array", "9bffffffffffffffff00"},
+		{"oversized map", "bb7fffffffffffffff00"},
+		{"uint beyond int64", "1b8000000000000000"},
+		{"negative beyond int64", "3b8000000000000000"},
+		{"indefinite array", "9f01ff"},
+		{"indefinite bytes", "5f4101ff"},
+		{"reserved additional info", "1c"},
+		{"break outside of item", "ff"},
+		{"byte string key", "a1410001"},
+		{"array key", "a1800001"},
+		{"unassigned simple value", "f810"},
+		{"too deep", strings.Repeat("81", cborMaxDepth+1) + "01"},
+		{"too deep through tags", strings.Repeat("c1", cborMaxDepth+1) + "01"},
+		{"too deep map", strings.Repeat("a101", cborMaxDepth+1) + "01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := decodeCBOR(unhex(tt.data)); err == nil {
+				t.Errorf("decodeCBOR(%s) = %#v, want error", tt.data, got)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORMaxDepth(t *testing.T) {
+	data := unhex(strings.Repeat("81", cborMaxDepth) + "01")
+	if _, _, err := decodeCBOR(data); err != nil {
+		t.Errorf("decodeCBOR of %d nested arrays error: %v", cborMaxDepth, err)
+	}
+}
+
+// unhex декодирует hex из таблиц тестов
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/mailer"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrEmailNotVerified        = apierror.Forbidden("email_not_verified", "email address is not verified")
+	ErrInvalidVerificationLink = apierror.Validation("invalid_verification_link", "invalid or expired verification link")
+)
+
+// SendVerificationEmail отправляет ссылку для подтверждения email. Письма
+// одному пользователю отправляются не чаще verificationResendInterval
+func (s *service) SendVerificationEmail(user *User) error {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	allowed, err := s.repo.MarkVerificationSent(user.ID, s.verificationResendInterval)
+	if err != nil {
+		return fmt.Errorf("failed to update verification status: %w", err)
+	}
+	if !allowed {
+		return nil
+	}
+
+	token, err := s.emailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	s.sendInBackground(user.ID, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Please confirm your email address by opening this link within %s:\n%s\n\n"+
+			"If you did not create an account, you can ignore this email.\n",
+			s.emailVerificationTTL, linkWithToken(s.emailVerificationURL, token)),
+	})
+
+	return nil
+}
+
+// ResendVerification повторно отправляет ссылку. Как и при сбросе пароля,
+// результат не зависит от того, зарегистрирован ли email
+func (s *service) ResendVerification(email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return s.SendVerificationEmail(user)
+}
+
+// VerifyEmail подтверждает email по подписанному токену из письма. Токен
+// привязан к адресу, поэтому после смены email старые ссылки не действуют
+func (s *service) VerifyEmail(tokenString string) error {
+	token, err := s.keys.Parse(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return ErrInvalidVerificationLink
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["type"] != "verify_email" {
+		return ErrInvalidVerificationLink
+	}
+
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return ErrInvalidVerificationLink
+	}
+	email, ok := claims["email"].(string)
+	if !ok {
+		return ErrInvalidVerificationLink
+	}
+
+	verified, err := s.repo.MarkEmailVerified(int(userIDFloat), email)
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	if !verified {
+		return ErrInvalidVerificationLink
+	}
+
+	return nil
+}
+
+// ConfirmEmail отмечает email подтвержденным, когда владение адресом доказано
+// иначе, например внешним провайдером. Пароль неподтвержденной учетной
+// записи мог задать не владелец адреса, поэтому подтверждается только
+// учетная запись без пароля: новая или после ResetCredentials
+func (s *service) ConfirmEmail(user *User) error {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+	if user.PasswordHash != unusablePasswordHash {
+		return errors.New("credentials of an unverified account must be reset before confirming its email")
+	}
+
+	if _, err := s.repo.MarkEmailVerified(user.ID, user.Email); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+func (s *service) emailVerificationToken(user *User) (string, error) {
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"jti":     tokenID,
+		"exp":     time.Now().Add(s.emailVerificationTTL).Unix(),
+		"iat":     time.Now().Unix(),
+		"type":    "verify_email",
+	}
+
+	return s.keys.Sign(claims)
+}
+
+// requireVerifiedEmail проверяет, что пользователю можно выдавать токены
+func (s *service) requireVerifiedEmail(user *User) error {
+	if s.requireVerifiedLogin && user.EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
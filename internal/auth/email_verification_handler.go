@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"auth-user-service/internal/apierror"
+)
+
+// VerifyEmail принимает токен из ссылки (GET ?token=) или из тела запроса
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var req VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, apierror.ErrInvalidJSON)
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		h.writeError(w, apierror.MissingField("token"))
+		return
+	}
+
+	if err := h.service.VerifyEmail(token); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Email address confirmed",
+	}
+
+	h.writeJSON(w, response, http.StatusOK)
+}
+
+// ResendVerification отвечает одинаково для любого email
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	if err := h.service.ResendVerification(req.Email); err != nil {
+		log.Printf("Error resending verification email: %v", err)
+	}
+
+	response := map[string]string{
+		"message": "If the account exists and is not verified, a confirmation link has been sent",
+	}
+
+	h.writeJSON(w, response, http.StatusAccepted)
+}
+
+// RequireVerifiedEmail пропускает только пользователей с подтвержденным
+// email. Используется после AuthMiddleware
+func (h *Handler) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("userID").(int)
+		if !ok {
+			h.writeError(w, apierror.ErrUnauthenticated)
+			return
+		}
+
+		user, err := h.service.GetUserByID(userID)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+
+		if user.EmailVerifiedAt == nil {
+			h.writeError(w, ErrEmailNotVerified)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
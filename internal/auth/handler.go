@@ -3,8 +3,24 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/validator"
+)
+
+var (
+	errMissingAuthorization    = apierror.Unauthorized("missing_authorization", "Authorization header required")
+	errInvalidToken            = apierror.Unauthorized("invalid_token", "Invalid token")
+	errInsufficientPermissions = apierror.Forbidden("insufficient_permissions", "Insufficient permissions")
 )
 
 type Handler struct {
@@ -16,115 +32,216 @@ func NewHandler(service Service) *Handler {
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	Email string `json:"email"`
-	ID    int    `json:"id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Email        string `json:"email"`
+	ID           int    `json:"id"`
+	// Warnings замечания к паролю, принятому при регистрации
+	Warnings []validator.PasswordViolation `json:"warnings,omitempty"`
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
+// NewAuthResponse формирует ответ об успешном входе
+func NewAuthResponse(user *User, tokens *Tokens) AuthResponse {
+	return AuthResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		Email:        user.Email,
+		ID:           user.ID,
+	}
+}
+
+// MFARequiredResponse ответ первого шага входа при включенном втором факторе
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Response возвращает тело ответа на вход: AuthResponse или MFARequiredResponse
+func (r *LoginResult) Response() interface{} {
+	if r.MFAToken != "" {
+		return MFARequiredResponse{
+			MFARequired: true,
+			MFAToken:    r.MFAToken,
+			ExpiresIn:   int(mfaTokenTTL.Seconds()),
+		}
+	}
+	return NewAuthResponse(r.User, r.Tokens)
 }
 
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, "Invalid request", http.StatusBadRequest)
+		h.writeError(w, apierror.ErrInvalidJSON)
 		return
 	}
 
 	// Валидация
 	if err := req.Validate(); err != nil {
-		h.writeError(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+		h.writeError(w, err)
 		return
 	}
 
-	user, err := h.service.Register(req.Email, req.Password, req.FirstName, req.LastName)
+	user, warnings, err := h.service.Register(req.Email, req.Password, req.FirstName, req.LastName)
 	if err != nil {
-		h.writeError(w, err.Error(), http.StatusBadRequest)
+		h.writePasswordError(w, "password", err)
 		return
 	}
 
-	token, err := h.service.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		h.writeError(w, "Failed to generate token", http.StatusInternalServerError)
+	tokens, err := h.service.IssueTokens(user, NewClientInfo(r))
+	if errors.Is(err, ErrEmailNotVerified) {
+		// Вход откроется после перехода по ссылке из письма
+		response := map[string]interface{}{
+			"message": "Registration successful, please confirm your email address",
+			"id":      user.ID,
+			"email":   user.Email,
+		}
+		if len(warnings) > 0 {
+			response["warnings"] = warnings
+		}
+		h.writeJSON(w, response, http.StatusCreated)
 		return
 	}
-
-	response := AuthResponse{
-		Token: token,
-		Email: user.Email,
-		ID:    user.ID,
+	if err != nil {
+		h.writeError(w, err)
+		return
 	}
 
+	response := NewAuthResponse(user, tokens)
+	response.Warnings = warnings
 	h.writeJSON(w, response, http.StatusCreated)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, "Invalid request", http.StatusBadRequest)
+		h.writeError(w, apierror.ErrInvalidJSON)
 		return
 	}
 
 	// Валидация
 	if err := req.Validate(); err != nil {
-		h.writeError(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+		h.writeError(w, err)
 		return
 	}
 
-	user, err := h.service.Login(req.Email, req.Password)
+	user, err := h.service.Login(req.Email, req.Password, ClientIP(r))
 	if err != nil {
-		h.writeError(w, "Invalid credentials", http.StatusUnauthorized)
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			h.writeLocked(w, locked)
+			return
+		}
+		h.writeError(w, err)
 		return
 	}
 
-	token, err := h.service.GenerateToken(user.ID, user.Email)
+	h.completeLogin(w, r, user)
+}
+
+// completeLogin выдает токены после проверки первого фактора или, если у
+// пользователя включена двухфакторная аутентификация, токен второго шага
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *User) {
+	result, err := h.service.CompleteLogin(user, NewClientInfo(r))
 	if err != nil {
-		h.writeError(w, "Failed to generate token", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
-	response := AuthResponse{
-		Token: token,
-		Email: user.Email,
-		ID:    user.ID,
-	}
-
-	h.writeJSON(w, response, http.StatusOK)
+	h.writeJSON(w, result.Response(), http.StatusOK)
 }
 
 func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value("userID").(int)
-	if !ok {
-		h.writeError(w, "User not authenticated", http.StatusUnauthorized)
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
 		return
 	}
 
-	user, err := h.service.GetUserByID(userID)
-	if err != nil {
-		h.writeError(w, "User not found", http.StatusNotFound)
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
 		return
 	}
 
-	newToken, err := h.service.GenerateToken(user.ID, user.Email)
+	user, tokens, err := h.service.RefreshToken(req.RefreshToken, "", NewClientInfo(r))
 	if err != nil {
-		h.writeError(w, "Failed to generate token", http.StatusInternalServerError)
+		if errors.Is(err, ErrRefreshTokenReused) {
+			// Клиенту не сообщается, что токен уже использован
+			err = ErrInvalidRefreshToken
+		}
+		h.writeError(w, err)
 		return
 	}
 
-	response := AuthResponse{
-		Token: newToken,
-		Email: user.Email,
-		ID:    user.ID,
+	h.writeAuthResponse(w, user, tokens, http.StatusOK)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*Claims)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	// Тело необязательно: без refresh токена отзывается только access токен
+	var req RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, apierror.ErrInvalidJSON)
+			return
+		}
+	}
+
+	if err := h.service.Logout(claims, req.RefreshToken); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	response := map[string]string{
+		"message": "Logout successful",
 	}
 
 	h.writeJSON(w, response, http.StatusOK)
 }
 
-func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
-	// В будущем можно добавить blacklist токенов в Redis
+// LogoutAllRequest структура для выхода со всех устройств
+type LogoutAllRequest struct {
+	Before *time.Time `json:"before,omitempty"`
+}
+
+// LogoutAll отзывает все токены пользователя, выданные до указанного
+// момента (по умолчанию — до текущего)
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req LogoutAllRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, apierror.ErrInvalidJSON)
+			return
+		}
+	}
+
+	before := time.Now()
+	if req.Before != nil && req.Before.Before(before) {
+		before = *req.Before
+	}
+
+	if err := h.service.LogoutAll(userID, before); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
 	response := map[string]string{
-		"message": "Logout successful",
+		"message": "Logged out from all devices",
 	}
 
 	h.writeJSON(w, response, http.StatusOK)
@@ -134,7 +251,18 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
-			h.writeError(w, "Authorization header required", http.StatusUnauthorized)
+			h.writeError(w, errMissingAuthorization)
+			return
+		}
+
+		// Персональный API ключ вместо JWT
+		if apiKey, ok := strings.CutPrefix(tokenString, "ApiKey "); ok {
+			claims, err := h.service.ValidateAPIKey(apiKey)
+			if err != nil {
+				h.writeError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
 			return
 		}
 
@@ -143,20 +271,129 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 			tokenString = tokenString[7:]
 		}
 
-		userID, email, err := h.service.ValidateToken(tokenString)
+		claims, err := h.service.ValidateToken(tokenString)
 		if err != nil {
-			h.writeError(w, "Invalid token", http.StatusUnauthorized)
+			if !errors.Is(err, ErrTokenRevoked) {
+				err = errInvalidToken
+			}
+			h.writeError(w, err)
 			return
 		}
 
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "userID", userID)
-		ctx = context.WithValue(ctx, "userEmail", email)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
 	})
 }
 
+// withClaims кладет в контекст данные аутентифицированного пользователя
+func withClaims(ctx context.Context, claims *Claims) context.Context {
+	ctx = context.WithValue(ctx, "userID", claims.UserID)
+	ctx = context.WithValue(ctx, "userEmail", claims.Email)
+	ctx = context.WithValue(ctx, "tokenClaims", claims)
+	return ctx
+}
+
+// RejectClientTokens отклоняет токены OIDC клиентов: они выданы для
+// /userinfo и не дают стороннему клиенту действовать от имени пользователя
+// в API сервиса. Используется после AuthMiddleware
+func (h *Handler) RejectClientTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("tokenClaims").(*Claims)
+		if !ok {
+			h.writeError(w, apierror.ErrUnauthenticated)
+			return
+		}
+		if claims.ClientID != "" {
+			h.writeError(w, errClientTokenNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission пропускает запрос, только если токену выданы все
+// перечисленные права. Токены OIDC клиентов прав пользователя не несут и
+// отклоняются всегда. Используется после AuthMiddleware
+func (h *Handler) RequirePermission(permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("tokenClaims").(*Claims)
+			if !ok {
+				h.writeError(w, apierror.ErrUnauthenticated)
+				return
+			}
+			if claims.ClientID != "" {
+				h.writeError(w, errClientTokenNotAllowed)
+				return
+			}
+
+			for _, permission := range permissions {
+				if !claims.HasPermission(permission) {
+					h.writeError(w, errInsufficientPermissions)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// JWKS отдает открытые ключи подписи, чтобы другие сервисы проверяли
+// токены локально
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeJSON(w, h.service.JWKS(), http.StatusOK)
+}
+
 // Вспомогательные методы
+func (h *Handler) writeAuthResponse(w http.ResponseWriter, user *User, tokens *Tokens, statusCode int) {
+	h.writeJSON(w, NewAuthResponse(user, tokens), statusCode)
+}
+
+// writeLocked отвечает 429 с заголовком Retry-After
+func (h *Handler) writeLocked(w http.ResponseWriter, locked *LockedError) {
+	WriteLocked(w, locked)
+}
+
+// WriteLocked отвечает 429 с заголовком Retry-After. Нужен обработчикам
+// других пакетов, которые проверяют пароль через Service
+func WriteLocked(w http.ResponseWriter, locked *LockedError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+	apierror.Write(w, ErrLoginLocked)
+}
+
+// ClientIP адрес клиента. За прокси RemoteAddr уже заменен middleware.RealIP
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// NewClientInfo описание устройства, с которого пришел запрос
+func NewClientInfo(r *http.Request) ClientInfo {
+	// Обрезаем по границе символа: половина UTF-8 символа не сохранится в БД
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "")
+	if len(userAgent) > 512 {
+		cut := 512
+		for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+			cut--
+		}
+		userAgent = userAgent[:cut]
+	}
+	return ClientInfo{UserAgent: userAgent, IP: ClientIP(r)}
+}
+
+// writePasswordError отвечает на ошибку установки пароля. Нарушения политики
+// паролей перечисляются в errors как ошибки поля field
+func (h *Handler) writePasswordError(w http.ResponseWriter, field string, err error) {
+	var policyErr *validator.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		err = policyErr.APIError(field)
+	}
+	h.writeError(w, err)
+}
+
 func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -165,8 +402,9 @@ func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode
 	}
 }
 
-func (h *Handler) writeError(w http.ResponseWriter, message string, statusCode int) {
-	h.writeJSON(w, ErrorResponse{Error: message}, statusCode)
+// writeError отвечает ошибкой в формате application/problem+json
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	apierror.Write(w, err)
 }
 
 func min(a, b int) int {
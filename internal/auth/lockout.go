@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+)
+
+// ErrLoginLocked слишком много неудачных попыток входа подряд
+var ErrLoginLocked = apierror.TooManyRequests("login_locked", "too many failed login attempts")
+
+// LockedError сообщает, через сколько можно повторить попытку входа
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLoginLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// LockoutPolicy первые FreeAttempts неудачных попыток проходят без задержки,
+// затем перед каждой следующей нужно выждать BaseDelay, удваивающийся с
+// каждой неудачей (не больше MaxDelay). После MaxAttempts вход блокируется на
+// LockoutDuration. Счетчик забывается через LockoutDuration без неудач
+type LockoutPolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	MaxAttempts     int
+	LockoutDuration time.Duration
+}
+
+// retryAfter возвращает, сколько осталось ждать до следующей попытки
+func (p LockoutPolicy) retryAfter(attempts *Attempts, now time.Time) time.Duration {
+	if attempts.Count == 0 {
+		return 0
+	}
+
+	var wait time.Duration
+	switch {
+	case p.MaxAttempts > 0 && attempts.Count >= p.MaxAttempts:
+		wait = p.LockoutDuration
+	case attempts.Count >= p.FreeAttempts:
+		wait = p.BaseDelay
+		for i := p.FreeAttempts; i < attempts.Count && wait < p.MaxDelay; i++ {
+			wait *= 2
+		}
+		if wait > p.MaxDelay {
+			wait = p.MaxDelay
+		}
+	default:
+		return 0
+	}
+
+	if remaining := attempts.LastFailure.Add(wait).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (p LockoutPolicy) ttl() time.Duration {
+	if p.MaxDelay > p.LockoutDuration {
+		return p.MaxDelay
+	}
+	return p.LockoutDuration
+}
+
+// LockoutStatus состояние счетчика неудачных попыток для администратора
+type LockoutStatus struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	Locked      bool       `json:"locked"`
+	RetryAfter  int        `json:"retry_after"` // секунды
+}
+
+func accountAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// attemptCounter счетчик неудачных попыток и политика задержек для него.
+// resetOnSuccess сбрасывает счетчик после удачной попытки, иначе удачная
+// попытка просто не засчитывается
+type attemptCounter struct {
+	key            string
+	policy         LockoutPolicy
+	resetOnSuccess bool
+}
+
+// loginCounters счетчики, которые учитываются при входе: по учетной записи,
+// чтобы остановить перебор паролей одного пользователя с разных адресов, и
+// по IP, чтобы остановить перебор многих учетных записей с одного адреса.
+// Счетчик IP после успешного входа не сбрасывается: иначе атакующий мог бы
+// обнулять его входом в собственную учетную запись
+func (s *service) loginCounters(email, ip string) []attemptCounter {
+	counters := []attemptCounter{{key: accountAttemptKey(email), policy: s.accountLockout, resetOnSuccess: true}}
+	if ip != "" {
+		counters = append(counters, attemptCounter{key: ipAttemptKey(ip), policy: s.ipLockout})
+	}
+	return counters
+}
+
+// attempt попытка, засчитанная в счетчиках до проверки. Ее нужно завершить
+// вызовом fail или succeed
+type attempt struct {
+	store    AttemptStore
+	counters []attemptCounter
+}
+
+// beginAttempt отказывает, если по одному из счетчиков еще действует
+// задержка, иначе засчитывает попытку. Счетчик увеличивается атомарно до
+// проверки, поэтому из параллельных попыток задержку обходит не больше
+// одной: остальные видят, что кто-то засчитал попытку после их чтения
+func (s *service) beginAttempt(ctx context.Context, counters []attemptCounter) (*attempt, error) {
+	now := time.Now()
+	a := &attempt{store: s.attempts}
+	for _, counter := range counters {
+		attempts, err := s.attempts.GetAttempts(ctx, counter.key)
+		if err != nil {
+			// Недоступность хранилища не должна закрывать вход
+			log.Printf("⚠️ Failed to get login attempts: %v", err)
+			continue
+		}
+		if wait := counter.policy.retryAfter(attempts, now); wait > 0 {
+			a.release(ctx)
+			return nil, &LockedError{RetryAfter: wait}
+		}
+
+		count, err := s.attempts.Reserve(ctx, counter.key, counter.policy.ttl())
+		if err != nil {
+			log.Printf("⚠️ Failed to record login attempt: %v", err)
+			continue
+		}
+		a.counters = append(a.counters, counter)
+
+		// Параллельную попытку считаем неудачей, случившейся только что
+		if count-1 > attempts.Count {
+			raced := &Attempts{Count: count - 1, LastFailure: now}
+			if wait := counter.policy.retryAfter(raced, now); wait > 0 {
+				a.release(ctx)
+				return nil, &LockedError{RetryAfter: wait}
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// fail оставляет попытку засчитанной и отмечает время неудачи
+func (a *attempt) fail(ctx context.Context) {
+	for _, counter := range a.counters {
+		if err := a.store.RecordFailure(ctx, counter.key, counter.policy.ttl()); err != nil {
+			log.Printf("⚠️ Failed to record login failure: %v", err)
+		}
+	}
+}
+
+// succeed сбрасывает счетчики с resetOnSuccess, а в остальных отменяет попытку
+func (a *attempt) succeed(ctx context.Context) {
+	for _, counter := range a.counters {
+		var err error
+		if counter.resetOnSuccess {
+			err = a.store.ResetAttempts(ctx, counter.key)
+		} else {
+			err = a.store.Release(ctx, counter.key)
+		}
+		if err != nil {
+			log.Printf("⚠️ Failed to reset login attempts: %v", err)
+		}
+	}
+}
+
+// release отменяет попытку, которую не удалось проверить
+func (a *attempt) release(ctx context.Context) {
+	for _, counter := range a.counters {
+		if err := a.store.Release(ctx, counter.key); err != nil {
+			log.Printf("⚠️ Failed to release login attempt: %v", err)
+		}
+	}
+}
+
+// LockoutStatus возвращает состояние счетчика для email или IP
+func (s *service) LockoutStatus(email, ip string) (*LockoutStatus, error) {
+	key, policy := accountAttemptKey(email), s.accountLockout
+	if email == "" {
+		key, policy = ipAttemptKey(ip), s.ipLockout
+	}
+
+	attempts, err := s.attempts.GetAttempts(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login attempts: %w", err)
+	}
+
+	status := &LockoutStatus{Key: key, Failures: attempts.Count}
+	if attempts.Count > 0 {
+		lastFailure := attempts.LastFailure
+		status.LastFailure = &lastFailure
+	}
+	if wait := policy.retryAfter(attempts, time.Now()); wait > 0 {
+		status.Locked = true
+		status.RetryAfter = int(wait.Round(time.Second).Seconds())
+	}
+
+	return status, nil
+}
+
+// Unlock снимает блокировку email или IP
+func (s *service) Unlock(email, ip string) error {
+	key := accountAttemptKey(email)
+	if email == "" {
+		key = ipAttemptKey(ip)
+	}
+	return s.attempts.ResetAttempts(context.Background(), key)
+}
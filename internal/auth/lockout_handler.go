@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"auth-user-service/internal/apierror"
+)
+
+// LockoutStatus состояние блокировки входа (?email= или ?ip=)
+func (h *Handler) LockoutStatus(w http.ResponseWriter, r *http.Request) {
+	email, ip, ok := h.lockoutTarget(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := h.service.LockoutStatus(email, ip)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, status, http.StatusOK)
+}
+
+// Unlock сбрасывает счетчик неудачных попыток (?email= или ?ip=)
+func (h *Handler) Unlock(w http.ResponseWriter, r *http.Request) {
+	email, ip, ok := h.lockoutTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Unlock(email, ip); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) lockoutTarget(w http.ResponseWriter, r *http.Request) (email, ip string, ok bool) {
+	email = strings.TrimSpace(r.URL.Query().Get("email"))
+	ip = strings.TrimSpace(r.URL.Query().Get("ip"))
+	if (email == "") == (ip == "") {
+		h.writeError(w, apierror.Validation("invalid_lockout_target", "Exactly one of email or ip is required"))
+		return "", "", false
+	}
+	return email, ip, true
+}
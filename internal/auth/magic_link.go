@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/mailer"
+)
+
+var ErrInvalidMagicLink = apierror.Validation("invalid_magic_link", "invalid or expired login link")
+
+// RequestMagicLink отправляет ссылку для входа без пароля. Как и при сбросе
+// пароля, результат не зависит от того, зарегистрирован ли email. Без
+// автоматической регистрации письмо получают только существующие пользователи
+func (s *service) RequestMagicLink(email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil && !s.magicLinkAutoRegister {
+		return nil
+	}
+	if user != nil && user.Disabled() {
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate login token: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.magicLinkTTL).UTC()
+	if err := s.repo.SaveMagicLinkToken(email, hashToken(token), expiresAt); err != nil {
+		return fmt.Errorf("failed to save login token: %w", err)
+	}
+
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+	s.sendInBackground(userID, mailer.Message{
+		To:      email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("To sign in, open this link within %s:\n%s\n\n"+
+			"The link can be used once. If you did not request it, you can ignore this email.\n",
+			s.magicLinkTTL, linkWithToken(s.magicLinkURL, token)),
+	})
+
+	return nil
+}
+
+// ConsumeMagicLink расходует токен из письма и возвращает пользователя,
+// которого нужно впустить. Переход по ссылке подтверждает email, а у
+// неподтвержденной учетной записи сбрасывает способы входа. Если
+// включена автоматическая регистрация, новый пользователь создается без
+// пароля, как при входе через внешнего провайдера
+func (s *service) ConsumeMagicLink(token string) (*User, error) {
+	email, err := s.repo.ConsumeMagicLinkToken(hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume login token: %w", err)
+	}
+	if email == "" {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := s.repo.GetUserByEmail(email)
+	if errors.Is(err, ErrUserNotFound) {
+		if !s.magicLinkAutoRegister {
+			return nil, ErrInvalidMagicLink
+		}
+		user, err = s.RegisterExternal(email, "", "")
+		if errors.Is(err, ErrUserExists) {
+			// Пользователь зарегистрировался, пока письмо шло
+			user, err = s.repo.GetUserByEmail(email)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.EmailVerifiedAt == nil && user.PasswordHash != unusablePasswordHash {
+		// Пароль неподтвержденной учетной записи мог задать не владелец
+		// email, поэтому он и другие способы входа сбрасываются
+		if err := s.ResetCredentials(user); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.ConfirmEmail(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"auth-user-service/internal/apierror"
+)
+
+// RequestMagicLink отвечает одинаково для любого email
+func (h *Handler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	if err := h.service.RequestMagicLink(req.Email); err != nil {
+		log.Printf("Error requesting magic link: %v", err)
+	}
+
+	response := map[string]string{
+		"message": "If sign-in by email is available for this address, a link has been sent",
+	}
+
+	h.writeJSON(w, response, http.StatusAccepted)
+}
+
+// ConsumeMagicLink принимает токен из ссылки (GET ?token=) или из тела
+// запроса и выдает токены так же, как вход по паролю
+func (h *Handler) ConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var req MagicLinkLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, apierror.ErrInvalidJSON)
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		h.writeError(w, apierror.MissingField("token"))
+		return
+	}
+
+	user, err := h.service.ConsumeMagicLink(token)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.completeLogin(w, r, user)
+}
@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"auth-user-service/internal/apierror"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaTokenTTL время на ввод второго фактора после проверки пароля
+const mfaTokenTTL = 5 * time.Minute
+
+const recoveryCodeCount = 10
+
+// mfaTokenMaxAttempts после стольких неверных кодов токен второго шага
+// отзывается, и вход нужно начинать заново с пароля
+const mfaTokenMaxAttempts = 5
+
+var (
+	ErrMFAAlreadyEnabled = apierror.Conflict("mfa_already_enabled", "two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = apierror.Validation("mfa_not_enrolled", "two-factor authentication enrollment not started")
+	ErrMFANotEnabled     = apierror.Validation("mfa_not_enabled", "two-factor authentication is not enabled")
+	ErrInvalidMFACode    = apierror.Unauthorized("invalid_mfa_code", "invalid two-factor code")
+	ErrInvalidMFAToken   = apierror.Unauthorized("invalid_mfa_token", "invalid or expired mfa token")
+)
+
+// LoginResult итог входа после проверки первого фактора: либо токены,
+// либо токен второго шага, если включена двухфакторная аутентификация
+type LoginResult struct {
+	User     *User
+	Tokens   *Tokens
+	MFAToken string
+}
+
+// TOTPEnrollment данные для подключения приложения-аутентификатора
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// EnrollTOTP создает новый секрет. Второй фактор включается только после
+// подтверждения кодом из приложения
+func (s *service) EnrollTOTP(userID int) (*TOTPEnrollment, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.repo.GetMFA(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mfa settings: %w", err)
+	}
+	if mfa.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	if err := s.repo.SaveMFASecret(userID, secret); err != nil {
+		return nil, fmt.Errorf("failed to save secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(s.mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP включает второй фактор и возвращает коды восстановления.
+// Коды показываются один раз, хранятся только их хэши
+func (s *service) ConfirmTOTP(userID int, code string) ([]string, error) {
+	mfa, err := s.repo.GetMFA(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mfa settings: %w", err)
+	}
+	if mfa == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	if mfa.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if err := s.checkTOTP(mfa, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashToken(normalizeRecoveryCode(c))
+	}
+
+	if err := s.repo.EnableMFA(userID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable mfa: %w", err)
+	}
+
+	return codes, nil
+}
+
+// DisableMFA отключает второй фактор после проверки текущего пароля, кода
+// TOTP или кода восстановления. Неверные подтверждения считаются вместе с
+// неверными кодами второго шага входа
+func (s *service) DisableMFA(userID int, password, code, recoveryCode string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	mfa, err := s.repo.GetMFA(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get mfa settings: %w", err)
+	}
+	if !mfa.Enabled() {
+		return ErrMFANotEnabled
+	}
+
+	ctx := context.Background()
+	attempt, err := s.beginAttempt(ctx, []attemptCounter{
+		{key: mfaAttemptKey(userID), policy: s.accountLockout, resetOnSuccess: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case recoveryCode != "":
+		used, err := s.repo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			attempt.release(ctx)
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if !used {
+			attempt.fail(ctx)
+			return ErrInvalidMFACode
+		}
+	case code != "":
+		if err := s.checkTOTP(mfa, code); err != nil {
+			attempt.fail(ctx)
+			return err
+		}
+	default:
+		if user.PasswordHash == unusablePasswordHash || s.hasher.Verify(password, user.PasswordHash) != nil {
+			attempt.fail(ctx)
+			return ErrInvalidCredentials
+		}
+	}
+
+	attempt.succeed(ctx)
+	return s.repo.DisableMFA(userID)
+}
+
+// MFAChallenge возвращает токен второго шага входа, если у пользователя
+// включен второй фактор, иначе пустую строку
+func (s *service) MFAChallenge(user *User) (string, error) {
+	mfa, err := s.repo.GetMFA(user.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get mfa settings: %w", err)
+	}
+	if !mfa.Enabled() {
+		return "", nil
+	}
+
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"jti":     tokenID,
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+		"iat":     time.Now().Unix(),
+		"type":    "mfa",
+	}
+
+	return s.keys.Sign(claims)
+}
+
+// CompleteLogin выдает токены или требует второй фактор
+func (s *service) CompleteLogin(user *User, client ClientInfo) (*LoginResult, error) {
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+
+	mfaToken, err := s.MFAChallenge(user)
+	if err != nil {
+		return nil, err
+	}
+	if mfaToken != "" {
+		return &LoginResult{User: user, MFAToken: mfaToken}, nil
+	}
+
+	tokens, err := s.IssueTokens(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{User: user, Tokens: tokens}, nil
+}
+
+// VerifyMFA завершает вход: проверяет токен первого шага и код TOTP или
+// одноразовый код восстановления. Токен действует один раз, неверные коды
+// считаются по пользователю, как неудачные попытки входа, а после
+// mfaTokenMaxAttempts неверных кодов токен отзывается
+func (s *service) VerifyMFA(mfaToken, code, recoveryCode string) (*User, error) {
+	token, err := s.keys.Parse(mfaToken, jwt.MapClaims{})
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["type"] != "mfa" {
+		return nil, ErrInvalidMFAToken
+	}
+
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, ErrInvalidMFAToken
+	}
+	userID := int(userIDFloat)
+
+	tokenID, _ := claims["jti"].(string)
+	expiresAt, err := claims.GetExpirationTime()
+	if tokenID == "" || err != nil || expiresAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	ctx := context.Background()
+	revoked, err := s.revocations.IsTokenRevoked(ctx, tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check mfa token: %w", err)
+	}
+	if revoked {
+		return nil, ErrInvalidMFAToken
+	}
+
+	attempt, err := s.beginAttempt(ctx, []attemptCounter{
+		{key: mfaAttemptKey(userID), policy: s.accountLockout, resetOnSuccess: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.repo.GetMFA(userID)
+	if err != nil {
+		attempt.release(ctx)
+		return nil, fmt.Errorf("failed to get mfa settings: %w", err)
+	}
+	if !mfa.Enabled() {
+		attempt.release(ctx)
+		return nil, ErrInvalidMFAToken
+	}
+
+	if recoveryCode != "" {
+		used, err := s.repo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			attempt.release(ctx)
+			return nil, fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if !used {
+			err = ErrInvalidMFACode
+		}
+	} else {
+		err = s.checkTOTP(mfa, code)
+	}
+	if err != nil {
+		attempt.fail(ctx)
+		s.failMFAToken(ctx, tokenID, expiresAt.Time)
+		return nil, err
+	}
+
+	attempt.succeed(ctx)
+	if err := s.revocations.RevokeToken(ctx, tokenID, expiresAt.Time); err != nil {
+		return nil, fmt.Errorf("failed to consume mfa token: %w", err)
+	}
+
+	return s.repo.GetUserByID(userID)
+}
+
+// failMFAToken засчитывает неверный код токену второго шага и отзывает
+// токен, когда неверных кодов набирается mfaTokenMaxAttempts
+func (s *service) failMFAToken(ctx context.Context, tokenID string, expiresAt time.Time) {
+	count, err := s.attempts.Reserve(ctx, "mfa_token:"+tokenID, mfaTokenTTL)
+	if err != nil {
+		log.Printf("⚠️ Failed to record mfa token failure: %v", err)
+		return
+	}
+	if count < mfaTokenMaxAttempts {
+		return
+	}
+	if err := s.revocations.RevokeToken(ctx, tokenID, expiresAt); err != nil {
+		log.Printf("⚠️ Failed to revoke mfa token: %v", err)
+	}
+}
+
+func mfaAttemptKey(userID int) string {
+	return "mfa:" + strconv.Itoa(userID)
+}
+
+// checkTOTP проверяет код и атомарно отмечает его шаг использованным
+func (s *service) checkTOTP(mfa *MFA, code string) error {
+	step, ok := validateTOTP(mfa.TOTPSecret, code, time.Now(), mfa.LastUsedStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	advanced, err := s.repo.AdvanceMFAStep(mfa.UserID, step)
+	if err != nil {
+		return fmt.Errorf("failed to save mfa step: %w", err)
+	}
+	if !advanced {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"auth-user-service/internal/apierror"
+)
+
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	enrollment, err := h.service.EnrollTOTP(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, enrollment, http.StatusOK)
+}
+
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			// Пользователь уже вошел, неверный код здесь — ошибка ввода
+			err = apierror.Validation("invalid_mfa_code", "Invalid code")
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string][]string{"recovery_codes": codes}, http.StatusOK)
+}
+
+func (h *Handler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req DisableMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	if err := h.service.DisableMFA(userID, req.Password, req.Code, req.RecoveryCode); err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			h.writeLocked(w, locked)
+			return
+		}
+		if errors.Is(err, ErrInvalidMFACode) {
+			err = apierror.Validation("invalid_mfa_code", "Invalid code")
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string]string{"status": "two-factor authentication disabled"}, http.StatusOK)
+}
+
+// LoginMFA второй шаг входа
+func (h *Handler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	user, err := h.service.VerifyMFA(req.MFAToken, req.Code, req.RecoveryCode)
+	if err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			h.writeLocked(w, locked)
+			return
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	tokens, err := h.service.IssueTokens(user, NewClientInfo(r))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeAuthResponse(w, user, tokens, http.StatusOK)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"auth-user-service/internal/apierror"
+)
+
+// ForgotPassword всегда отвечает одинаково, независимо от существования email
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	if err := h.service.ForgotPassword(req.Email); err != nil {
+		log.Printf("Error requesting password reset: %v", err)
+	}
+
+	response := map[string]string{
+		"message": "If the account exists, a password reset link has been sent",
+	}
+
+	h.writeJSON(w, response, http.StatusAccepted)
+}
+
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	warnings, err := h.service.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		h.writePasswordError(w, "password", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Password has been reset",
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+
+	h.writeJSON(w, response, http.StatusOK)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/mailer"
+	"auth-user-service/internal/validator"
+)
+
+var ErrInvalidResetToken = apierror.Validation("invalid_reset_token", "invalid or expired password reset token")
+
+// ForgotPassword отправляет ссылку для сброса пароля. Для неизвестного email
+// ошибка не возвращается, чтобы по ответу нельзя было узнать о существовании
+// учетной записи
+func (s *service) ForgotPassword(email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return s.sendPasswordResetLink(user,
+		"Someone requested a password reset for your account.",
+		"If you did not request this, you can ignore this email.")
+}
+
+// ForcePasswordReset вызывается администратором: текущий пароль перестает
+// действовать, все сессии завершаются, а пользователь получает ссылку для
+// выбора нового пароля
+func (s *service) ForcePasswordReset(userID int) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.repo.ClearPassword(userID); err != nil {
+		return fmt.Errorf("failed to clear password: %w", err)
+	}
+	if err := s.LogoutAll(userID, time.Now()); err != nil {
+		return err
+	}
+
+	return s.sendPasswordResetLink(user,
+		"An administrator has reset the password for your account.",
+		"Until you choose a new password you will not be able to sign in with one.")
+}
+
+// sendPasswordResetLink создает одноразовый токен сброса и отправляет ссылку
+func (s *service) sendPasswordResetLink(user *User, intro, outro string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.passwordResetTTL).UTC()
+	if err := s.repo.SavePasswordResetToken(user.ID, hashToken(token), expiresAt); err != nil {
+		return fmt.Errorf("failed to save reset token: %w", err)
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("%s\n\n"+
+			"To choose a new password, open this link within %s:\n%s\n\n"+
+			"%s\n",
+			intro, s.passwordResetTTL, linkWithToken(s.passwordResetURL, token), outro),
+	}
+
+	s.sendInBackground(user.ID, msg)
+
+	return nil
+}
+
+// ResetPassword устанавливает новый пароль по одноразовому токену и
+// завершает все сессии пользователя
+func (s *service) ResetPassword(token, password string) ([]validator.PasswordViolation, error) {
+	userID, err := s.repo.GetPasswordResetUserID(hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reset token: %w", err)
+	}
+	if userID == 0 {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	warnings, err := s.validateNewPassword(password, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	userID, err = s.repo.ResetPassword(hashToken(token), hashedPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+	if userID == 0 {
+		return nil, ErrInvalidResetToken
+	}
+
+	if err := s.LogoutAll(userID, time.Now()); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// sendInBackground отправляет письмо, не дожидаясь SMTP сервера: так время
+// ответа не зависит от того, существует ли учетная запись
+func (s *service) sendInBackground(userID int, msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("⚠️ Failed to send %q email to user %d: %v", msg.Subject, userID, err)
+		}
+	}()
+}
+
+// linkWithToken добавляет токен к адресу страницы фронтенда
+func linkWithToken(baseURL, token string) string {
+	separator := "?"
+	if strings.Contains(baseURL, "?") {
+		separator = "&"
+	}
+	return baseURL + separator + "token=" + url.QueryEscape(token)
+}
@@ -4,28 +4,162 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/validator"
+
+	"github.com/lib/pq"
 )
 
+// ErrUserNotFound возвращается, если пользователь не найден
+var ErrUserNotFound = apierror.NotFound("user_not_found", "User not found")
+
 // Repository интерфейс
 type Repository interface {
 	CreateUser(email, passwordHash, firstName, lastName string) (int, error)
 	GetUserByEmail(email string) (*User, error)
 	GetUserByID(id int) (*User, error)
 	UserExists(email string) (bool, error)
-	SaveRefreshToken(userID int, token string, expiresAt time.Time) error
-	GetUserByRefreshToken(token string) (*User, error)
-	DeleteRefreshToken(token string) error
+	SaveRefreshToken(userID int, familyID, tokenHash string, expiresAt time.Time, grant *ClientGrant) error
+	CreateSession(userID int, familyID string, client ClientInfo) error
+	TouchSession(familyID string, client ClientInfo) error
+	ListSessions(userID int) ([]Session, error)
+	GetSession(userID, id int) (*Session, error)
+	GetRefreshToken(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(old *RefreshToken, newTokenHash string, expiresAt time.Time) (bool, error)
+	RevokeTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int, before time.Time) error
+	GetMFA(userID int) (*MFA, error)
+	SaveMFASecret(userID int, secret string) error
+	EnableMFA(userID int, recoveryCodeHashes []string) error
+	DisableMFA(userID int) error
+	AdvanceMFAStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CreatePasskey(credential *PasskeyCredential) error
+	GetPasskey(credentialID []byte) (*PasskeyCredential, error)
+	ListPasskeys(userID int) ([]PasskeyCredential, error)
+	UpdatePasskeySignCount(id int, signCount uint32) error
+	DeletePasskey(userID, id int) (bool, error)
+	ResetCredentials(userID int) error
+	SavePasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error
+	GetPasswordResetUserID(tokenHash string) (int, error)
+	ResetPassword(tokenHash, passwordHash string) (int, error)
+	MarkEmailVerified(userID int, email string) (bool, error)
+	MarkVerificationSent(userID int, interval time.Duration) (bool, error)
+	GetUserAccess(userID int) (roles, permissions []string, err error)
+	ClearPassword(userID int) (bool, error)
+	CreateAPIKey(key *APIKey) error
+	GetAPIKeyByPrefix(prefix string) (*APIKey, error)
+	ListAPIKeys(userID int) ([]APIKey, error)
+	RevokeAPIKey(userID, id int) (bool, error)
+	TouchAPIKey(id int) error
+	UpdatePassword(userID int, passwordHash string) error
+	RehashPassword(userID int, oldHash, newHash string) error
+	SaveMagicLinkToken(email, tokenHash string, expiresAt time.Time) error
+	ConsumeMagicLinkToken(tokenHash string) (string, error)
+	ChangeEmail(userID int, oldEmail, newEmail string) (bool, error)
 }
 
 // User представляет пользователя системы
 type User struct {
-	ID           int       `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	FirstName    string    `json:"first_name,omitempty"`
-	LastName     string    `json:"last_name,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int    `json:"id"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+	FirstName    string `json:"first_name,omitempty"`
+	LastName     string `json:"last_name,omitempty"`
+	// EmailVerifiedAt nil, пока пользователь не перешел по ссылке из письма
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// DisabledAt задается администратором, отключенный пользователь не может войти
+	DisabledAt *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Disabled сообщает, что учетная запись отключена администратором
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// RefreshToken запись refresh токена. Токены одной цепочки ротации
+// объединены общим FamilyID
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+	// Grant не nil, если семья выдана стороннему OIDC клиенту
+	Grant *ClientGrant
+}
+
+// ClientInfo устройство, с которого открыта или обновлена сессия
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// Session семья refresh токенов вместе с устройством, на котором выполнен
+// вход. Сессия активна, пока в семье есть неиспользованный и неотозванный
+// refresh токен
+type Session struct {
+	ID         int       `json:"id"`
+	FamilyID   string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// MFA настройки второго фактора пользователя
+type MFA struct {
+	UserID       int
+	TOTPSecret   string
+	EnabledAt    *time.Time
+	LastUsedStep int64
+}
+
+// Enabled сообщает, что подключение TOTP подтверждено
+func (m *MFA) Enabled() bool {
+	return m != nil && m.EnabledAt != nil
+}
+
+// PasskeyCredential зарегистрированный ключ WebAuthn
+type PasskeyCredential struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"-"`
+	CredentialID []byte     `json:"-"`
+	PublicKey    []byte     `json:"-"` // COSE_Key
+	SignCount    uint32     `json:"-"`
+	Transports   []string   `json:"transports"`
+	Name         string     `json:"name"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// APIKey персональный ключ для скриптов и интеграций. Сам ключ не хранится,
+// только его хэш
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAPIKeyRequest структура для создания API ключа
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 // RegisterRequest структура для регистрации
@@ -42,20 +176,139 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// MFALoginRequest второй шаг входа: код TOTP или код восстановления
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFACodeRequest подтверждение подключения TOTP
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableMFARequest отключение второго фактора. Подтверждается текущим
+// паролем, кодом TOTP или кодом восстановления: у пользователей, входящих
+// через внешних провайдеров, пароля нет
+type DisableMFARequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// ForgotPasswordRequest запрос ссылки для сброса пароля
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest установка нового пароля по токену из письма
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// MagicLinkRequest запрос ссылки для входа без пароля
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkLoginRequest вход по токену из письма
+type MagicLinkLoginRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmailRequest подтверждение email по токену из письма
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// RefreshRequest структура для обновления и отзыва токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // Validate валидирует структуру запроса
 func (r *RegisterRequest) Validate() error {
-	// Простая валидация
-	if r.Email == "" || r.Password == "" || r.FirstName == "" || r.LastName == "" {
-		return errors.New("all fields are required")
+	var fields apierror.Fields
+	fields.Required("email", r.Email)
+	if r.Email != "" && !validator.ValidateEmail(r.Email) {
+		fields.Add("email", "invalid_email", "must be a valid email address")
 	}
-	return nil
+	fields.Required("password", r.Password)
+	fields.Required("first_name", r.FirstName)
+	fields.Required("last_name", r.LastName)
+	return fields.Err()
 }
 
 func (r *LoginRequest) Validate() error {
-	if r.Email == "" || r.Password == "" {
-		return errors.New("email and password are required")
+	var fields apierror.Fields
+	fields.Required("email", r.Email)
+	fields.Required("password", r.Password)
+	return fields.Err()
+}
+
+func (r *MFALoginRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("mfa_token", r.MFAToken)
+	if r.Code == "" && r.RecoveryCode == "" {
+		fields.Add("code", "required", "code or recovery_code is required")
+	}
+	return fields.Err()
+}
+
+func (r *MFACodeRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("code", r.Code)
+	return fields.Err()
+}
+
+func (r *DisableMFARequest) Validate() error {
+	var fields apierror.Fields
+	if r.Password == "" && r.Code == "" && r.RecoveryCode == "" {
+		fields.Add("password", "required", "password, code or recovery_code is required")
+	}
+	return fields.Err()
+}
+
+func (r *ForgotPasswordRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("email", r.Email)
+	return fields.Err()
+}
+
+func (r *MagicLinkRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("email", r.Email)
+	if r.Email != "" && !validator.ValidateEmail(r.Email) {
+		fields.Add("email", "invalid_email", "must be a valid email address")
 	}
-	return nil
+	return fields.Err()
+}
+
+func (r *ResetPasswordRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("token", r.Token)
+	fields.Required("password", r.Password)
+	return fields.Err()
+}
+
+func (r *CreateAPIKeyRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("name", r.Name)
+	if len(r.Name) > 100 {
+		fields.Add("name", "too_long", "must be at most 100 characters")
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		fields.Add("expires_at", "not_in_future", "must be in the future")
+	}
+	return fields.Err()
+}
+
+func (r *RefreshRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("refresh_token", r.RefreshToken)
+	return fields.Err()
 }
 
 // PostgreSQL реализация репозитория
@@ -81,15 +334,17 @@ func (r *postgresRepository) CreateUser(email, passwordHash, firstName, lastName
 	return id, nil
 }
 
+// GetUserByEmail ищет пользователя по email без учета регистра: адрес
+// хранится так, как его ввели, но принадлежит одному пользователю
 func (r *postgresRepository) GetUserByEmail(email string) (*User, error) {
 	var user User
 	err := r.db.QueryRow(
-		"SELECT id, email, password_hash, first_name, last_name, created_at, updated_at FROM users WHERE email = $1",
+		"SELECT id, email, password_hash, first_name, last_name, email_verified_at, disabled_at, created_at, updated_at FROM users WHERE LOWER(email) = LOWER($1)",
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -101,12 +356,12 @@ func (r *postgresRepository) GetUserByEmail(email string) (*User, error) {
 func (r *postgresRepository) GetUserByID(id int) (*User, error) {
 	var user User
 	err := r.db.QueryRow(
-		"SELECT id, email, password_hash, first_name, last_name, created_at, updated_at FROM users WHERE id = $1",
+		"SELECT id, email, password_hash, first_name, last_name, email_verified_at, disabled_at, created_at, updated_at FROM users WHERE id = $1",
 		id,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -118,44 +373,749 @@ func (r *postgresRepository) GetUserByID(id int) (*User, error) {
 func (r *postgresRepository) UserExists(email string) (bool, error) {
 	var exists bool
 	err := r.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)",
+		"SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))",
 		email,
 	).Scan(&exists)
 	return exists, err
 }
 
-func (r *postgresRepository) SaveRefreshToken(userID int, token string, expiresAt time.Time) error {
+// SaveRefreshToken сохраняет первый токен семьи. grant задается для семей
+// OIDC клиентов
+func (r *postgresRepository) SaveRefreshToken(userID int, familyID, tokenHash string, expiresAt time.Time, grant *ClientGrant) error {
+	var clientID sql.NullString
+	var scope string
+	if grant != nil {
+		clientID = sql.NullString{String: grant.ClientID, Valid: true}
+		scope = grant.Scope
+	}
+
 	_, err := r.db.Exec(
-		"INSERT INTO auth_tokens (user_id, token, expires_at) VALUES ($1, $2, $3)",
-		userID, token, expiresAt,
+		"INSERT INTO auth_tokens (user_id, family_id, token, expires_at, client_id, scope) VALUES ($1, $2, $3, $4, $5, $6)",
+		userID, familyID, tokenHash, expiresAt, clientID, scope,
 	)
 	return err
 }
 
-func (r *postgresRepository) GetUserByRefreshToken(token string) (*User, error) {
-	var user User
+func (r *postgresRepository) CreateSession(userID int, familyID string, client ClientInfo) error {
+	_, err := r.db.Exec(
+		"INSERT INTO sessions (user_id, family_id, user_agent, ip_address) VALUES ($1, $2, $3, $4)",
+		userID, familyID, client.UserAgent, client.IP,
+	)
+	return err
+}
+
+// TouchSession отмечает обновление токенов сессии и запоминает последнее устройство
+func (r *postgresRepository) TouchSession(familyID string, client ClientInfo) error {
+	_, err := r.db.Exec(
+		"UPDATE sessions SET user_agent = $1, ip_address = $2, last_used_at = NOW() WHERE family_id = $3",
+		client.UserAgent, client.IP, familyID,
+	)
+	return err
+}
+
+// activeSession условие активности сессии s
+const activeSession = `EXISTS (
+	SELECT 1 FROM auth_tokens t
+	WHERE t.family_id = s.family_id AND t.used_at IS NULL AND t.revoked_at IS NULL AND t.expires_at > NOW()
+)`
+
+// ListSessions возвращает активные сессии пользователя, последние сверху
+func (r *postgresRepository) ListSessions(userID int) ([]Session, error) {
+	rows, err := r.db.Query(
+		`SELECT s.id, s.family_id, s.user_agent, s.ip_address, s.created_at, s.last_used_at
+		 FROM sessions s
+		 WHERE s.user_id = $1 AND `+activeSession+`
+		 ORDER BY s.last_used_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.FamilyID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// GetSession возвращает активную сессию пользователя или nil
+func (r *postgresRepository) GetSession(userID, id int) (*Session, error) {
+	var session Session
 	err := r.db.QueryRow(
-		`SELECT u.id, u.email, u.password_hash, u.first_name, u.last_name, u.created_at, u.updated_at 
-		 FROM users u 
-		 JOIN auth_tokens t ON u.id = t.user_id 
-		 WHERE t.token = $1 AND t.expires_at > $2`,
-		token, time.Now(),
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName, &user.CreatedAt, &user.UpdatedAt)
+		`SELECT s.id, s.family_id, s.user_agent, s.ip_address, s.created_at, s.last_used_at
+		 FROM sessions s
+		 WHERE s.id = $1 AND s.user_id = $2 AND `+activeSession,
+		id, userID,
+	).Scan(&session.ID, &session.FamilyID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt)
 
 	if errors.Is(err, sql.ErrNoRows) {
-		return nil, errors.New("invalid or expired refresh token")
+		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return &session, nil
 }
 
-func (r *postgresRepository) DeleteRefreshToken(token string) error {
+func (r *postgresRepository) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	var clientID sql.NullString
+	var scope string
+	err := r.db.QueryRow(
+		`SELECT id, user_id, family_id, token, expires_at, used_at, revoked_at, created_at, client_id, scope
+		 FROM auth_tokens
+		 WHERE token = $1`,
+		tokenHash,
+	).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt, &token.CreatedAt, &clientID, &scope)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if clientID.Valid {
+		token.Grant = &ClientGrant{ClientID: clientID.String, Scope: scope}
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken помечает старый токен использованным и сохраняет новый
+// в той же семье с тем же клиентом. Возвращает false, если старый токен уже был использован
+// или отозван параллельным запросом
+func (r *postgresRepository) RotateRefreshToken(old *RefreshToken, newTokenHash string, expiresAt time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE auth_tokens SET used_at = NOW() WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL",
+		old.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO auth_tokens (user_id, family_id, token, expires_at, client_id, scope)
+		 SELECT user_id, family_id, $2, $3, client_id, scope FROM auth_tokens WHERE id = $1`,
+		old.ID, newTokenHash, expiresAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (r *postgresRepository) RevokeTokenFamily(familyID string) error {
 	_, err := r.db.Exec(
-		"DELETE FROM auth_tokens WHERE token = $1",
-		token,
+		"UPDATE auth_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL",
+		familyID,
+	)
+	return err
+}
+
+func (r *postgresRepository) RevokeUserRefreshTokens(userID int, before time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE auth_tokens SET revoked_at = NOW()
+		 WHERE family_id IN (SELECT family_id FROM auth_tokens WHERE user_id = $1 AND created_at <= $2)
+		 AND revoked_at IS NULL`,
+		userID, before.UTC(),
+	)
+	return err
+}
+
+func (r *postgresRepository) GetMFA(userID int) (*MFA, error) {
+	var mfa MFA
+	err := r.db.QueryRow(
+		"SELECT user_id, totp_secret, enabled_at, last_used_step FROM user_mfa WHERE user_id = $1",
+		userID,
+	).Scan(&mfa.UserID, &mfa.TOTPSecret, &mfa.EnabledAt, &mfa.LastUsedStep)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// SaveMFASecret сохраняет секрет неподтвержденного подключения. Уже
+// включенный второй фактор не перезаписывается
+func (r *postgresRepository) SaveMFASecret(userID int, secret string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO user_mfa (user_id, totp_secret) VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET totp_secret = EXCLUDED.totp_secret, last_used_step = 0, created_at = NOW()
+		 WHERE user_mfa.enabled_at IS NULL`,
+		userID, secret,
+	)
+	return err
+}
+
+// EnableMFA включает второй фактор и заменяет коды восстановления
+func (r *postgresRepository) EnableMFA(userID int, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE user_mfa SET enabled_at = NOW() WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(
+			"INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *postgresRepository) DisableMFA(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AdvanceMFAStep запоминает использованный шаг TOTP. Возвращает false, если
+// этот или более поздний шаг уже был использован параллельным запросом
+func (r *postgresRepository) AdvanceMFAStep(userID int, step int64) (bool, error) {
+	result, err := r.db.Exec(
+		"UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1",
+		step, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *postgresRepository) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := r.db.Exec(
+		"UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *postgresRepository) CreatePasskey(credential *PasskeyCredential) error {
+	err := r.db.QueryRow(
+		`INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, transports, name)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		credential.UserID, credential.CredentialID, credential.PublicKey, int64(credential.SignCount),
+		pq.Array(credential.Transports), credential.Name,
+	).Scan(&credential.ID, &credential.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrPasskeyExists
+	}
+	return err
+}
+
+func (r *postgresRepository) GetPasskey(credentialID []byte) (*PasskeyCredential, error) {
+	var credential PasskeyCredential
+	var signCount int64
+	err := r.db.QueryRow(
+		`SELECT id, user_id, credential_id, public_key, sign_count, transports, name, last_used_at, created_at
+		 FROM webauthn_credentials
+		 WHERE credential_id = $1`,
+		credentialID,
+	).Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.PublicKey, &signCount,
+		pq.Array(&credential.Transports), &credential.Name, &credential.LastUsedAt, &credential.CreatedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	credential.SignCount = uint32(signCount)
+	return &credential, nil
+}
+
+func (r *postgresRepository) ListPasskeys(userID int) ([]PasskeyCredential, error) {
+	rows, err := r.db.Query(
+		`SELECT id, user_id, credential_id, public_key, sign_count, transports, name, last_used_at, created_at
+		 FROM webauthn_credentials
+		 WHERE user_id = $1
+		 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []PasskeyCredential{}
+	for rows.Next() {
+		var credential PasskeyCredential
+		var signCount int64
+		err := rows.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.PublicKey, &signCount,
+			pq.Array(&credential.Transports), &credential.Name, &credential.LastUsedAt, &credential.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		credential.SignCount = uint32(signCount)
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (r *postgresRepository) UpdatePasskeySignCount(id int, signCount uint32) error {
+	_, err := r.db.Exec(
+		"UPDATE webauthn_credentials SET sign_count = $1, last_used_at = NOW() WHERE id = $2",
+		int64(signCount), id,
+	)
+	return err
+}
+
+func (r *postgresRepository) DeletePasskey(userID, id int) (bool, error) {
+	result, err := r.db.Exec(
+		"DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *postgresRepository) SavePasswordResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		userID, tokenHash, expiresAt,
+	)
+	return err
+}
+
+// GetPasswordResetUserID возвращает владельца действующего токена сброса
+// или 0, если токен не найден, истек или уже использован
+func (r *postgresRepository) GetPasswordResetUserID(tokenHash string) (int, error) {
+	var userID int
+	err := r.db.QueryRow(
+		"SELECT user_id FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()",
+		tokenHash,
+	).Scan(&userID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ResetPassword атомарно расходует токен и меняет пароль. Остальные
+// неиспользованные токены пользователя тоже становятся недействительными.
+// Переход по ссылке из письма заодно подтверждает email.
+// Возвращает 0, если токен не найден, истек или уже использован
+func (r *postgresRepository) ResetPassword(tokenHash, passwordHash string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(
+		`UPDATE password_reset_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id`,
+		tokenHash,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	// Сброс по ссылке из письма подтверждает email. Способы входа, заданные
+	// до подтверждения, мог добавить не владелец адреса, поэтому они убираются
+	var unverified bool
+	err = tx.QueryRow(
+		"SELECT email_verified_at IS NULL FROM users WHERE id = $1 FOR UPDATE",
+		userID,
+	).Scan(&unverified)
+	if err != nil {
+		return 0, err
+	}
+	if unverified {
+		if err := resetCredentials(tx, userID); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users
+		 SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		 WHERE id = $2`,
+		passwordHash, userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// ClearPassword делает текущий пароль недействительным. Возвращает false,
+// если пользователь не найден
+func (r *postgresRepository) ClearPassword(userID int) (bool, error) {
+	result, err := r.db.Exec(
+		"UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2",
+		unusablePasswordHash, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (r *postgresRepository) UpdatePassword(userID int, passwordHash string) error {
+	_, err := r.db.Exec(
+		"UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2",
+		passwordHash, userID,
+	)
+	return err
+}
+
+// RehashPassword заменяет хэш тем же паролем, посчитанным заново. Хэш не
+// меняется, если пароль успели сменить после проверки
+func (r *postgresRepository) RehashPassword(userID int, oldHash, newHash string) error {
+	_, err := r.db.Exec(
+		"UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3",
+		newHash, userID, oldHash,
+	)
+	return err
+}
+
+func (r *postgresRepository) SaveMagicLinkToken(email, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		"INSERT INTO magic_link_tokens (email, token_hash, expires_at) VALUES ($1, $2, $3)",
+		email, tokenHash, expiresAt,
+	)
+	return err
+}
+
+// ConsumeMagicLinkToken расходует токен и возвращает email, для которого он
+// выдан. Остальные неиспользованные ссылки на этот email тоже перестают
+// действовать. Возвращает пустую строку, если токен не найден, истек или
+// уже использован
+func (r *postgresRepository) ConsumeMagicLinkToken(tokenHash string) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRow(
+		`UPDATE magic_link_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING email`,
+		tokenHash,
+	).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE magic_link_tokens SET used_at = NOW() WHERE LOWER(email) = LOWER($1) AND used_at IS NULL",
+		email,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return email, tx.Commit()
+}
+
+// ChangeEmail меняет email, если он не изменился с момента отправки ссылки.
+// Новый адрес подтвержден переходом по ссылке. Возвращает false, если у
+// пользователя уже другой email
+func (r *postgresRepository) ChangeEmail(userID int, oldEmail, newEmail string) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE users SET email = $1, email_verified_at = NOW(), updated_at = NOW()
+		 WHERE id = $2 AND email = $3`,
+		newEmail, userID, oldEmail,
+	)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return false, ErrUserExists
+	}
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// MarkEmailVerified подтверждает email, если он не изменился с момента
+// отправки ссылки. Возвращает false, если у пользователя уже другой email
+func (r *postgresRepository) MarkEmailVerified(userID int, email string) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+		 WHERE id = $1 AND email = $2`,
+		userID, email,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// MarkVerificationSent отмечает отправку письма. Возвращает false, если email
+// уже подтвержден или предыдущее письмо было отправлено меньше interval назад
+func (r *postgresRepository) MarkVerificationSent(userID int, interval time.Duration) (bool, error) {
+	result, err := r.db.Exec(
+		`UPDATE users SET verification_sent_at = NOW()
+		 WHERE id = $1 AND email_verified_at IS NULL
+		 AND (verification_sent_at IS NULL OR verification_sent_at < NOW() - $2 * INTERVAL '1 second')`,
+		userID, interval.Seconds(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetUserAccess возвращает роли пользователя и все права, выданные через них
+func (r *postgresRepository) GetUserAccess(userID int) ([]string, []string, error) {
+	rows, err := r.db.Query(
+		`SELECT r.name, p.name
+		 FROM user_roles ur
+		 JOIN roles r ON r.id = ur.role_id
+		 LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		 LEFT JOIN permissions p ON p.id = rp.permission_id
+		 WHERE ur.user_id = $1
+		 ORDER BY r.name, p.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	permissions := []string{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var role string
+		var permission sql.NullString
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1] != role {
+			roles = append(roles, role)
+		}
+		if permission.Valid && !seen[permission.String] {
+			seen[permission.String] = true
+			permissions = append(permissions, permission.String)
+		}
+	}
+
+	return roles, permissions, rows.Err()
+}
+
+// ResetCredentials убирает все способы входа пользователя: пароль, второй
+// фактор и passkey, и отзывает его API ключи
+func (r *postgresRepository) ResetCredentials(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := resetCredentials(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func resetCredentials(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec(
+		"UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2",
+		unusablePasswordHash, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webauthn_credentials WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+func (r *postgresRepository) CreateAPIKey(key *APIKey) error {
+	// Столбец TIMESTAMP без зоны: смещение из запроса клиента иначе потерялось бы
+	if key.ExpiresAt != nil {
+		expiresAt := key.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+
+	return r.db.QueryRow(
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.ExpiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (r *postgresRepository) GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	var key APIKey
+	err := r.db.QueryRow(
+		`SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+		 FROM api_keys
+		 WHERE prefix = $1`,
+		prefix,
+	).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes),
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// ListAPIKeys возвращает неотозванные ключи пользователя, включая истекшие
+func (r *postgresRepository) ListAPIKeys(userID int) ([]APIKey, error) {
+	rows, err := r.db.Query(
+		`SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+		 FROM api_keys
+		 WHERE user_id = $1 AND revoked_at IS NULL
+		 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes),
+			&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *postgresRepository) RevokeAPIKey(userID, id int) (bool, error) {
+	result, err := r.db.Exec(
+		"UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// TouchAPIKey обновляет время последнего использования не чаще раза в минуту
+func (r *postgresRepository) TouchAPIKey(id int) error {
+	_, err := r.db.Exec(
+		`UPDATE api_keys SET last_used_at = NOW()
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`,
+		id,
 	)
 	return err
 }
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/redis"
+)
+
+// RevocationStore хранит отозванные access токены. Отзыв действует до
+// истечения токена, после этого запись можно забыть
+//
+// RevokeUserTokens отзывает токены пользователя, выданные раньше before.
+// Отметка хранится с точностью до миллисекунды
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	RevokeUserTokens(ctx context.Context, userID int, before time.Time) error
+	UserTokensRevokedBefore(ctx context.Context, userID int) (time.Time, error)
+}
+
+type RedisClient interface {
+	Get(ctx context.Context, key string, dest interface{}) error
+	GetDel(ctx context.Context, key string, dest interface{}) error
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Incr(ctx context.Context, key string, expiration time.Duration) (int64, error)
+	SetMax(ctx context.Context, key string, value int64, expiration time.Duration) error
+	Decr(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Redis реализация
+type redisRevocationStore struct {
+	redis          RedisClient
+	accessTokenTTL time.Duration
+}
+
+// NewRedisRevocationStore создает хранилище отзывов в Redis. accessTokenTTL
+// определяет, сколько хранить отметку "выйти везде": дольше нее не живет
+// ни один из затронутых токенов
+func NewRedisRevocationStore(redisClient RedisClient, accessTokenTTL time.Duration) RevocationStore {
+	return &redisRevocationStore{
+		redis:          redisClient,
+		accessTokenTTL: accessTokenTTL,
+	}
+}
+
+func (s *redisRevocationStore) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.redis.Set(ctx, "revoked_token:"+tokenID, true, ttl)
+}
+
+func (s *redisRevocationStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	err := s.redis.Get(ctx, "revoked_token:"+tokenID, &revoked)
+	if errors.Is(err, redis.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
+
+// userRevocationKey отметка "выйти везде" в миллисекундах
+func userRevocationKey(userID int) string {
+	return fmt.Sprintf("tokens_revoked_before_ms:%d", userID)
+}
+
+func (s *redisRevocationStore) RevokeUserTokens(ctx context.Context, userID int, before time.Time) error {
+	// Отметка не сдвигается назад, если параллельно записан более поздний отзыв
+	return s.redis.SetMax(ctx, userRevocationKey(userID), before.UnixMilli(), s.accessTokenTTL)
+}
+
+func (s *redisRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	var millis int64
+	err := s.redis.Get(ctx, userRevocationKey(userID), &millis)
+	if errors.Is(err, redis.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// PostgreSQL реализация, используется когда Redis не настроен
+type postgresRevocationStore struct {
+	db *sql.DB
+}
+
+func NewPostgresRevocationStore(db *sql.DB) RevocationStore {
+	return &postgresRevocationStore{db: db}
+}
+
+func (s *postgresRevocationStore) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	// Попутно чистим записи об уже истекших токенах
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < NOW()"); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING",
+		tokenID, expiresAt.UTC(),
+	)
+	return err
+}
+
+func (s *postgresRevocationStore) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1)",
+		tokenID,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *postgresRevocationStore) RevokeUserTokens(ctx context.Context, userID int, before time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_token_revocations (user_id, revoked_before) VALUES ($1, $2)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before)`,
+		userID, before.UTC(),
+	)
+	return err
+}
+
+func (s *postgresRevocationStore) UserTokensRevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	var before time.Time
+	err := s.db.QueryRowContext(ctx,
+		"SELECT revoked_before FROM user_token_revocations WHERE user_id = $1",
+		userID,
+	).Scan(&before)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return before, err
+}
@@ -1,150 +1,788 @@
 package auth
 
 import (
-	"database/sql"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/keyring"
+	"auth-user-service/internal/mailer"
+	"auth-user-service/internal/password"
+	"auth-user-service/internal/validator"
+
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Service interface {
-	Register(email, password, firstName, lastName string) (*User, error)
-	Login(email, password string) (*User, error)
-	GenerateToken(userID int, email string) (string, error)
-	ValidateToken(tokenString string) (int, string, error)
+	Register(email, password, firstName, lastName string) (*User, []validator.PasswordViolation, error)
+	RegisterExternal(email, firstName, lastName string) (*User, error)
+	Login(email, password, ip string) (*User, error)
+	GenerateToken(userID int, email, sessionID string) (string, error)
+	ValidateToken(tokenString string) (*Claims, error)
+	JWKS() keyring.JSONWebKeySet
 	GetUserByID(userID int) (*User, error)
-	RefreshToken(refreshToken string) (string, error)
-	Logout(refreshToken string) error
+	GetUserByEmail(email string) (*User, error)
+	IssueTokens(user *User, client ClientInfo) (*Tokens, error)
+	IssueClientTokens(user *User, grant ClientGrant, client ClientInfo) (*Tokens, error)
+	// RefreshToken обновляет токены. clientID пустой для собственных клиентов
+	// сервиса и совпадает с клиентом, которому выдана семья, для OIDC клиентов
+	RefreshToken(refreshToken, clientID string, client ClientInfo) (*User, *Tokens, error)
+	Logout(claims *Claims, refreshToken string) error
+	LogoutAll(userID int, before time.Time) error
+	EnrollTOTP(userID int) (*TOTPEnrollment, error)
+	ConfirmTOTP(userID int, code string) ([]string, error)
+	DisableMFA(userID int, password, code, recoveryCode string) error
+	MFAChallenge(user *User) (string, error)
+	CompleteLogin(user *User, client ClientInfo) (*LoginResult, error)
+	VerifyMFA(mfaToken, code, recoveryCode string) (*User, error)
+	BeginPasskeyRegistration(userID int) (*PasskeyCreationOptions, error)
+	FinishPasskeyRegistration(userID int, req *PasskeyRegistrationRequest) (*PasskeyCredential, error)
+	BeginPasskeyLogin(email string) (*PasskeyRequestOptions, error)
+	FinishPasskeyLogin(req *PasskeyLoginRequest) (*User, error)
+	ListPasskeys(userID int) ([]PasskeyCredential, error)
+	DeletePasskey(userID, id int) error
+	ResetCredentials(user *User) error
+	ForgotPassword(email string) error
+	ResetPassword(token, password string) ([]validator.PasswordViolation, error)
+	RequestMagicLink(email string) error
+	ConsumeMagicLink(token string) (*User, error)
+	SendVerificationEmail(user *User) error
+	ResendVerification(email string) error
+	VerifyEmail(token string) error
+	ConfirmEmail(user *User) error
+	ForcePasswordReset(userID int) error
+	ListSessions(userID int, currentSessionID string) ([]Session, error)
+	RevokeSession(userID, id int) error
+	CreateAPIKey(userID int, req *CreateAPIKeyRequest) (*APIKey, string, error)
+	ListAPIKeys(userID int) ([]APIKey, error)
+	RevokeAPIKey(userID, id int) error
+	ValidateAPIKey(key string) (*Claims, error)
+	ChangePassword(userID int, currentPassword, newPassword, currentSessionID string) ([]validator.PasswordViolation, error)
+	RequestEmailChange(userID int, currentPassword, newEmail string) error
+	ConfirmEmailChange(token string) (int, error)
+	LockoutStatus(email, ip string) (*LockoutStatus, error)
+	Unlock(email, ip string) error
+}
+
+// unusablePasswordHash не совпадает ни с одним паролем: такие пользователи
+// входят только через внешних провайдеров, пока не зададут пароль
+const unusablePasswordHash = "!"
+
+var (
+	ErrUserExists          = apierror.Conflict("user_exists", "user already exists")
+	ErrInvalidCredentials  = apierror.Unauthorized("invalid_credentials", "invalid credentials")
+	ErrInvalidRefreshToken = apierror.Unauthorized("invalid_refresh_token", "invalid or expired refresh token")
+	ErrRefreshTokenReused  = apierror.Unauthorized("refresh_token_reused", "refresh token reuse detected")
+	ErrTokenRevoked        = apierror.Unauthorized("token_revoked", "token has been revoked")
+	ErrAccountDisabled     = apierror.Forbidden("account_disabled", "account is disabled")
+)
+
+// Claims данные проверенного access токена
+type Claims struct {
+	UserID  int
+	Email   string
+	TokenID string
+	// SessionID семья refresh токенов, при входе в которую выдан токен
+	SessionID string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Roles и Scopes роли пользователя и права из них на момент выдачи токена
+	Roles  []string
+	Scopes []string
+	// APIKeyID не 0, если запрос аутентифицирован API ключом, а не токеном
+	APIKeyID int
+	// ClientID OIDC клиент, которому выдан токен. Scopes такого токена —
+	// scope OpenID Connect, а не права пользователя
+	ClientID string
+}
+
+// HasPermission сообщает, выдано ли токену право permission
+func (c *Claims) HasPermission(permission string) bool {
+	for _, scope := range c.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Config настройки выпуска токенов
+type Config struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// MFAIssuer название сервиса в приложении-аутентификаторе
+	MFAIssuer string
+	WebAuthn  WebAuthnConfig
+	// PasswordResetURL страница фронтенда, на которую ведет ссылка из письма
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+	// EmailVerificationURL адрес из письма для подтверждения email
+	EmailVerificationURL       string
+	EmailVerificationTTL       time.Duration
+	VerificationResendInterval time.Duration
+	// MagicLinkURL адрес из письма для входа без пароля, токен добавляется
+	// параметром token. MagicLinkAutoRegister создает пользователя для
+	// незнакомого email
+	MagicLinkURL          string
+	MagicLinkTTL          time.Duration
+	MagicLinkAutoRegister bool
+	// EmailChangeURL адрес из письма для подтверждения нового email
+	EmailChangeURL string
+	// RequireVerifiedEmailForLogin запрещает вход до подтверждения email
+	RequireVerifiedEmailForLogin bool
+	// PasswordHasher хэширует новые пароли, по умолчанию argon2id. Хэши
+	// других алгоритмов обновляются при входе
+	PasswordHasher password.Hasher
+	// PasswordPolicy требования к новым паролям
+	PasswordPolicy *validator.PasswordPolicy
+	// BreachedPasswords локальная база утекших паролей, nil отключает проверку.
+	// При WarnBreachedPasswords утекший пароль принимается с предупреждением
+	BreachedPasswords     *validator.BreachedPasswords
+	WarnBreachedPasswords bool
+	// AccountLockout и IPLockout задержки после неудачных попыток входа
+	AccountLockout LockoutPolicy
+	IPLockout      LockoutPolicy
+}
+
+// ClientGrant доступ, выданный пользователем стороннему OIDC клиенту
+type ClientGrant struct {
+	ClientID string
+	Scope    string
+}
+
+// offline сообщает, что клиенту разрешено обновлять токены без пользователя
+func (g *ClientGrant) offline() bool {
+	return slices.Contains(strings.Fields(g.Scope), "offline_access")
+}
+
+// Tokens пара токенов, выдаваемая при входе и обновлении
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // время жизни access токена в секундах
 }
 
 type service struct {
-	repo      Repository
-	jwtSecret string
+	repo            Repository
+	revocations     RevocationStore
+	challenges      ChallengeStore
+	mailer          mailer.Mailer
+	attempts        AttemptStore
+	keys            *keyring.Keyring
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	mfaIssuer       string
+	webauthn        WebAuthnConfig
+
+	passwordResetURL string
+	passwordResetTTL time.Duration
+
+	magicLinkURL          string
+	magicLinkTTL          time.Duration
+	magicLinkAutoRegister bool
+
+	emailVerificationURL       string
+	emailVerificationTTL       time.Duration
+	verificationResendInterval time.Duration
+	requireVerifiedLogin       bool
+	emailChangeURL             string
+
+	accountLockout LockoutPolicy
+	ipLockout      LockoutPolicy
+	passwordPolicy *validator.PasswordPolicy
+	hasher         password.Hasher
+	dummyHash      string
+	breached       *validator.BreachedPasswords
+	warnBreached   bool
 }
 
-func NewService(repo Repository, jwtSecret string) Service {
-	if jwtSecret == "" {
-		panic("JWT secret is required")
+func NewService(repo Repository, revocations RevocationStore, challenges ChallengeStore, attempts AttemptStore, mailer mailer.Mailer, keys *keyring.Keyring, cfg Config) Service {
+	if keys == nil {
+		panic("JWT signing keys are required")
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = 15 * time.Minute
+	}
+	if cfg.RefreshTokenTTL <= 0 {
+		cfg.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+	if cfg.MFAIssuer == "" {
+		cfg.MFAIssuer = "Auth User Service"
+	}
+	if cfg.WebAuthn.RPID == "" {
+		cfg.WebAuthn.RPID = "localhost"
+	}
+	if cfg.WebAuthn.RPName == "" {
+		cfg.WebAuthn.RPName = cfg.MFAIssuer
+	}
+	if cfg.PasswordResetTTL <= 0 {
+		cfg.PasswordResetTTL = time.Hour
+	}
+	if cfg.MagicLinkTTL <= 0 {
+		cfg.MagicLinkTTL = 15 * time.Minute
+	}
+	if cfg.EmailVerificationTTL <= 0 {
+		cfg.EmailVerificationTTL = 24 * time.Hour
+	}
+	if cfg.VerificationResendInterval <= 0 {
+		cfg.VerificationResendInterval = time.Minute
+	}
+	if cfg.AccountLockout == (LockoutPolicy{}) {
+		cfg.AccountLockout = LockoutPolicy{
+			FreeAttempts:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			MaxAttempts:     10,
+			LockoutDuration: 15 * time.Minute,
+		}
+	}
+	if cfg.IPLockout == (LockoutPolicy{}) {
+		cfg.IPLockout = LockoutPolicy{
+			FreeAttempts:    20,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			MaxAttempts:     100,
+			LockoutDuration: 15 * time.Minute,
+		}
+	}
+	if cfg.PasswordPolicy == nil {
+		policy, err := validator.NewPasswordPolicy(8, 72, 3, "")
+		if err != nil {
+			panic(err)
+		}
+		cfg.PasswordPolicy = policy
+	}
+	if cfg.PasswordHasher == nil {
+		hasher, err := password.NewHasher(password.Config{})
+		if err != nil {
+			panic(err)
+		}
+		cfg.PasswordHasher = hasher
+	}
+	// Хэш, который проверяется вместо отсутствующего, чтобы время ответа на
+	// вход не выдавало, есть ли учетная запись и задан ли у нее пароль
+	dummyHash, err := cfg.PasswordHasher.Hash("dummy password")
+	if err != nil {
+		panic(err)
 	}
 	return &service{
-		repo:      repo,
-		jwtSecret: jwtSecret,
+		repo:            repo,
+		revocations:     revocations,
+		challenges:      challenges,
+		mailer:          mailer,
+		attempts:        attempts,
+		keys:            keys,
+		accessTokenTTL:  cfg.AccessTokenTTL,
+		refreshTokenTTL: cfg.RefreshTokenTTL,
+		mfaIssuer:       cfg.MFAIssuer,
+		webauthn:        cfg.WebAuthn,
+
+		passwordResetURL: cfg.PasswordResetURL,
+		passwordResetTTL: cfg.PasswordResetTTL,
+
+		magicLinkURL:          cfg.MagicLinkURL,
+		magicLinkTTL:          cfg.MagicLinkTTL,
+		magicLinkAutoRegister: cfg.MagicLinkAutoRegister,
+
+		emailVerificationURL:       cfg.EmailVerificationURL,
+		emailVerificationTTL:       cfg.EmailVerificationTTL,
+		verificationResendInterval: cfg.VerificationResendInterval,
+		requireVerifiedLogin:       cfg.RequireVerifiedEmailForLogin,
+		emailChangeURL:             cfg.EmailChangeURL,
+
+		accountLockout: cfg.AccountLockout,
+		ipLockout:      cfg.IPLockout,
+		passwordPolicy: cfg.PasswordPolicy,
+		hasher:         cfg.PasswordHasher,
+		dummyHash:      dummyHash,
+		breached:       cfg.BreachedPasswords,
+		warnBreached:   cfg.WarnBreachedPasswords,
 	}
 }
 
-func (s *service) Register(email, password, firstName, lastName string) (*User, error) {
+// Register создает пользователя. Вместе с ним возвращаются предупреждения
+// о пароле, который принят, но не рекомендуется (см. validateNewPassword)
+func (s *service) Register(email, password, firstName, lastName string) (*User, []validator.PasswordViolation, error) {
 	// Проверяем существует ли пользователь
 	exists, err := s.repo.UserExists(email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check user existence: %w", err)
+		return nil, nil, fmt.Errorf("failed to check user existence: %w", err)
 	}
 	if exists {
-		return nil, errors.New("user already exists")
+		return nil, nil, ErrUserExists
+	}
+
+	warnings, err := s.validateNewPassword(password, email)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Хэшируем пароль
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 
 	// Создаем пользователя
-	userID, err := s.repo.CreateUser(email, string(hashedPassword), firstName, lastName)
+	userID, err := s.repo.CreateUser(email, hashedPassword, firstName, lastName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return nil, nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
 	// Получаем созданного пользователя
 	user, err := s.repo.GetUserByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get created user: %w", err)
+		return nil, nil, fmt.Errorf("failed to get created user: %w", err)
 	}
 
-	return user, nil
+	if err := s.SendVerificationEmail(user); err != nil {
+		log.Printf("⚠️ Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	return user, warnings, nil
+}
+
+// RegisterExternal создает пользователя без пароля для входа через внешнего провайдера
+func (s *service) RegisterExternal(email, firstName, lastName string) (*User, error) {
+	exists, err := s.repo.UserExists(email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if exists {
+		return nil, ErrUserExists
+	}
+
+	userID, err := s.repo.CreateUser(email, unusablePasswordHash, firstName, lastName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	// Провайдер уже подтвердил владение email
+	if _, err := s.repo.MarkEmailVerified(userID, email); err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	return s.repo.GetUserByID(userID)
 }
 
-func (s *service) Login(email, password string) (*User, error) {
+// Login проверяет пароль. Неудачные попытки считаются по email и по IP, и
+// после нескольких неудач следующие попытки отклоняются без проверки пароля
+func (s *service) Login(email, password, ip string) (*User, error) {
+	ctx := context.Background()
+
+	attempt, err := s.beginAttempt(ctx, s.loginCounters(email, ip))
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := s.repo.GetUserByEmail(email)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("invalid credentials")
+		if errors.Is(err, ErrUserNotFound) {
+			// Неизвестный email проверяется и считается так же, чтобы ни
+			// время ответа, ни блокировка не выдавали существование учетной записи
+			s.hasher.Verify(password, s.dummyHash)
+			attempt.fail(ctx)
+			return nil, ErrInvalidCredentials
 		}
+		attempt.release(ctx)
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	// Проверяем пароль
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-	if err != nil {
-		return nil, errors.New("invalid credentials")
+	passwordHash := user.PasswordHash
+	if passwordHash == unusablePasswordHash {
+		passwordHash = s.dummyHash
+	}
+	if err := s.hasher.Verify(password, passwordHash); err != nil || user.PasswordHash == unusablePasswordHash {
+		attempt.fail(ctx)
+		return nil, ErrInvalidCredentials
+	}
+
+	attempt.succeed(ctx)
+	s.rehashPassword(user, password)
+
+	// Только после проверки пароля, чтобы не раскрывать статус чужих учетных записей
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	if err := s.requireVerifiedEmail(user); err != nil {
+		return nil, err
 	}
 
 	return user, nil
 }
 
-func (s *service) GenerateToken(userID int, email string) (string, error) {
+// GenerateToken выдает access токен. Роли и права (claim scope, через
+// пробел, как в RFC 9068) попадают в токен, поэтому изменения ролей
+// вступают в силу со следующим токеном. sessionID (claim sid) позволяет
+// отозвать токен вместе с сессией
+func (s *service) GenerateToken(userID int, email, sessionID string) (string, error) {
+	return s.generateToken(userID, email, sessionID, nil)
+}
+
+// generateToken выдает access токен. Токен OIDC клиента (grant не nil)
+// адресован клиенту (claims aud и client_id) и несет только выданные ему
+// scope: ролей и прав пользователя в нем нет
+func (s *service) generateToken(userID int, email, sessionID string, grant *ClientGrant) (string, error) {
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	// iat с миллисекундами, чтобы токен, выданный сразу после "выйти
+	// везде", не попадал под отзыв
 	claims := jwt.MapClaims{
 		"user_id": userID,
 		"email":   email,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 дней
-		"iat":     time.Now().Unix(),
+		"sid":     sessionID,
+		"jti":     tokenID,
+		"exp":     time.Now().Add(s.accessTokenTTL).Unix(),
+		"iat":     float64(time.Now().UnixMilli()) / 1000,
 		"type":    "access",
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	if grant != nil {
+		claims["aud"] = grant.ClientID
+		claims["client_id"] = grant.ClientID
+		claims["scope"] = grant.Scope
+	} else {
+		roles, permissions, err := s.repo.GetUserAccess(userID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get user roles: %w", err)
+		}
+		claims["roles"] = roles
+		claims["scope"] = strings.Join(permissions, " ")
+	}
+
+	return s.keys.Sign(claims)
 }
 
-func (s *service) ValidateToken(tokenString string) (int, string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.jwtSecret), nil
-	})
+func (s *service) ValidateToken(tokenString string) (*Claims, error) {
+	token, err := s.keys.Parse(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if tokenType, _ := mapClaims["type"].(string); tokenType != "access" {
+		return nil, errors.New("invalid token: not an access token")
+	}
 
+	claims, err := parseAccessClaims(mapClaims)
 	if err != nil {
-		return 0, "", err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userIDFloat, ok := claims["user_id"].(float64)
-		if !ok {
-			return 0, "", errors.New("invalid token: user_id not found")
+	if err := s.checkRevocation(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// JWKS возвращает открытые ключи для проверки токенов другими сервисами
+func (s *service) JWKS() keyring.JSONWebKeySet {
+	return s.keys.JWKS()
+}
+
+func parseAccessClaims(mapClaims jwt.MapClaims) (*Claims, error) {
+	userIDFloat, ok := mapClaims["user_id"].(float64)
+	if !ok {
+		return nil, errors.New("invalid token: user_id not found")
+	}
+
+	email, ok := mapClaims["email"].(string)
+	if !ok {
+		return nil, errors.New("invalid token: email not found")
+	}
+
+	tokenID, ok := mapClaims["jti"].(string)
+	if !ok || tokenID == "" {
+		return nil, errors.New("invalid token: jti not found")
+	}
+
+	// GetIssuedAt отбрасывает доли секунды, поэтому iat читается напрямую
+	iat, ok := mapClaims["iat"].(float64)
+	if !ok {
+		return nil, errors.New("invalid token: iat not found")
+	}
+	issuedAt := time.UnixMilli(int64(math.Round(iat * 1000)))
+
+	expiresAt, err := mapClaims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return nil, errors.New("invalid token: exp not found")
+	}
+
+	// Токены, выданные до появления ролей, их не содержат
+	var roles []string
+	if values, ok := mapClaims["roles"].([]interface{}); ok {
+		for _, value := range values {
+			if role, ok := value.(string); ok {
+				roles = append(roles, role)
+			}
 		}
+	}
+	scope, _ := mapClaims["scope"].(string)
+	sessionID, _ := mapClaims["sid"].(string)
+	clientID, _ := mapClaims["client_id"].(string)
+
+	return &Claims{
+		UserID:    int(userIDFloat),
+		Email:     email,
+		TokenID:   tokenID,
+		SessionID: sessionID,
+		IssuedAt:  issuedAt,
+		ExpiresAt: expiresAt.Time,
+		Roles:     roles,
+		Scopes:    strings.Fields(scope),
+		ClientID:  clientID,
+	}, nil
+}
+
+// checkRevocation проверяет отзыв конкретного токена и отметку "выйти везде".
+// Отзываются токены, выданные раньше отметки, с точностью до миллисекунды
+func (s *service) checkRevocation(claims *Claims) error {
+	ctx := context.Background()
+
+	revoked, err := s.revocations.IsTokenRevoked(ctx, claims.TokenID)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
 
-		email, ok := claims["email"].(string)
-		if !ok {
-			return 0, "", errors.New("invalid token: email not found")
+	if claims.SessionID != "" {
+		revoked, err := s.revocations.IsTokenRevoked(ctx, sessionRevocationID(claims.SessionID))
+		if err != nil {
+			return fmt.Errorf("failed to check session revocation: %w", err)
 		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
 
-		return int(userIDFloat), email, nil
+	revokedBefore, err := s.revocations.UserTokensRevokedBefore(ctx, claims.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if !revokedBefore.IsZero() && claims.IssuedAt.UnixMilli() < revokedBefore.UnixMilli() {
+		return ErrTokenRevoked
 	}
 
-	return 0, "", errors.New("invalid token")
+	return nil
 }
 
 func (s *service) GetUserByID(userID int) (*User, error) {
 	return s.repo.GetUserByID(userID)
 }
 
-func (s *service) RefreshToken(refreshToken string) (string, error) {
-	user, err := s.repo.GetUserByRefreshToken(refreshToken)
+func (s *service) GetUserByEmail(email string) (*User, error) {
+	return s.repo.GetUserByEmail(email)
+}
+
+// IssueTokens открывает новую сессию на устройстве client: выдает access
+// токен и первый refresh токен новой семьи
+func (s *service) IssueTokens(user *User, client ClientInfo) (*Tokens, error) {
+	return s.issueTokens(user, nil, client)
+}
+
+// IssueClientTokens выдает токены стороннему OIDC клиенту. Они ограничены
+// выданными клиенту scope, а refresh токен выдается только с offline_access
+func (s *service) IssueClientTokens(user *User, grant ClientGrant, client ClientInfo) (*Tokens, error) {
+	return s.issueTokens(user, &grant, client)
+}
+
+func (s *service) issueTokens(user *User, grant *ClientGrant, client ClientInfo) (*Tokens, error) {
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	if err := s.requireVerifiedEmail(user); err != nil {
+		return nil, err
+	}
+
+	familyID, err := randomToken(16)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	accessToken, err := s.generateToken(user.ID, user.Email, familyID, grant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	if err := s.repo.CreateSession(user.ID, familyID, client); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	// OIDC клиент без offline_access получает только access токен
+	if grant != nil && !grant.offline() {
+		return &Tokens{
+			AccessToken: accessToken,
+			ExpiresIn:   int(s.accessTokenTTL.Seconds()),
+		}, nil
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.refreshTokenTTL).UTC()
+	if err := s.repo.SaveRefreshToken(user.ID, familyID, hashToken(refreshToken), expiresAt, grant); err != nil {
+		return nil, fmt.Errorf("failed to save refresh token: %w", err)
+	}
+
+	return &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RefreshToken обменивает refresh токен на новую пару токенов. Каждый refresh
+// токен одноразовый: повторное предъявление уже использованного токена
+// означает его утечку, поэтому отзывается вся семья. Токен принимается только
+// от клиента, которому выдана семья
+func (s *service) RefreshToken(refreshToken, clientID string, client ClientInfo) (*User, *Tokens, error) {
+	stored, err := s.repo.GetRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if stored == nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	var grantClientID string
+	if stored.Grant != nil {
+		grantClientID = stored.Grant.ClientID
+	}
+	if grantClientID != clientID {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		return nil, nil, s.revokeReusedFamily(stored)
+	}
+
+	user, err := s.repo.GetUserByID(stored.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Disabled() {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	newRefreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	rotated, err := s.repo.RotateRefreshToken(stored, hashToken(newRefreshToken), time.Now().Add(s.refreshTokenTTL).UTC())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		// Токен успели использовать между чтением и ротацией
+		return nil, nil, s.revokeReusedFamily(stored)
+	}
+
+	if err := s.repo.TouchSession(stored.FamilyID, client); err != nil {
+		log.Printf("⚠️ Failed to update session: %v", err)
+	}
+
+	accessToken, err := s.generateToken(user.ID, user.Email, stored.FamilyID, stored.Grant)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate new token: %w", err)
+	}
+
+	return user, &Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int(s.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *service) revokeReusedFamily(token *RefreshToken) error {
+	log.Printf("⚠️ Refresh token reuse detected for user %d, revoking family %s", token.UserID, token.FamilyID)
+	if err := s.repo.RevokeTokenFamily(token.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+// Logout отзывает текущий access токен и семью refresh токена, если он
+// принадлежит пользователю
+func (s *service) Logout(claims *Claims, refreshToken string) error {
+	if err := s.revocations.RevokeToken(context.Background(), claims.TokenID, claims.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
 	}
 
-	// Генерируем новый access token
-	newToken, err := s.GenerateToken(user.ID, user.Email)
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.repo.GetRefreshToken(hashToken(refreshToken))
 	if err != nil {
-		return "", fmt.Errorf("failed to generate new token: %w", err)
+		return fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if stored == nil || stored.UserID != claims.UserID {
+		return nil
 	}
+	return s.repo.RevokeTokenFamily(stored.FamilyID)
+}
+
+// LogoutAll отзывает все токены пользователя, выданные до before
+func (s *service) LogoutAll(userID int, before time.Time) error {
+	if err := s.revocations.RevokeUserTokens(context.Background(), userID, before); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	if err := s.repo.RevokeUserRefreshTokens(userID, before); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// ResetCredentials убирает все способы входа, которые мог задать не
+// владелец email, и отзывает все выданные токены. Вызывается, когда владение
+// email доказано другим способом, например входом через провайдера
+func (s *service) ResetCredentials(user *User) error {
+	if err := s.repo.ResetCredentials(user.ID); err != nil {
+		return fmt.Errorf("failed to reset credentials: %w", err)
+	}
+	if err := s.LogoutAll(user.ID, time.Now()); err != nil {
+		return err
+	}
+
+	user.PasswordHash = unusablePasswordHash
+	return nil
+}
 
-	return newToken, nil
+// randomToken возвращает криптостойкую случайную строку из n байт
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func (s *service) Logout(refreshToken string) error {
-	return s.repo.DeleteRefreshToken(refreshToken)
+// hashToken хэширует непрозрачный токен для хранения в БД
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"auth-user-service/internal/apierror"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListSessions устройства, на которых пользователь вошел в систему
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*Claims)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	sessions, err := h.service.ListSessions(claims.UserID, claims.SessionID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, sessions, http.StatusOK)
+}
+
+// RevokeSession завершает сессию на одном устройстве
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	if err := h.service.RevokeSession(userID, id); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/apierror"
+)
+
+var ErrSessionNotFound = apierror.NotFound("session_not_found", "session not found")
+
+// sessionRevocationID ключ отзыва сессии в RevocationStore. Access токены
+// несут id сессии в claim sid, поэтому отзыв сессии действует и на них
+func sessionRevocationID(sessionID string) string {
+	return "session:" + sessionID
+}
+
+// ListSessions возвращает активные сессии пользователя и отмечает ту, из
+// которой сделан запрос
+func (s *service) ListSessions(userID int, currentSessionID string) ([]Session, error) {
+	sessions, err := s.repo.ListSessions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for i := range sessions {
+		sessions[i].Current = currentSessionID != "" && sessions[i].FamilyID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession завершает сессию на одном устройстве: отзывает ее refresh
+// токены и уже выданные access токены
+func (s *service) RevokeSession(userID, id int) error {
+	session, err := s.repo.GetSession(userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return ErrSessionNotFound
+	}
+
+	return s.endSession(session)
+}
+
+// revokeOtherSessions завершает все сессии пользователя, кроме текущей
+func (s *service) revokeOtherSessions(userID int, currentSessionID string) error {
+	sessions, err := s.repo.ListSessions(userID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for i := range sessions {
+		if sessions[i].FamilyID == currentSessionID {
+			continue
+		}
+		if err := s.endSession(&sessions[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) endSession(session *Session) error {
+	if err := s.repo.RevokeTokenFamily(session.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	// Access токены сессии живут не дольше accessTokenTTL
+	expiresAt := time.Now().Add(s.accessTokenTTL)
+	if err := s.revocations.RevokeToken(context.Background(), sessionRevocationID(session.FamilyID), expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,636 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+)
+
+// webauthnTimeout время на завершение церемонии регистрации или входа
+const webauthnTimeout = 5 * time.Minute
+
+// Церемонии WebAuthn, значение поля type в clientDataJSON
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// Флаги authenticatorData
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagAttestedData   = 0x40
+	flagExtensionsData = 0x80
+)
+
+// Алгоритмы COSE, которые поддерживает сервис
+const (
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+)
+
+var (
+	ErrInvalidPasskey   = apierror.Validation("invalid_passkey", "invalid passkey response")
+	ErrPasskeyChallenge = apierror.Validation("passkey_challenge_expired", "passkey challenge expired or not found")
+	ErrPasskeyExists    = apierror.Conflict("passkey_exists", "passkey already registered")
+	ErrPasskeyNotFound  = apierror.NotFound("passkey_not_found", "passkey not found")
+	// ErrPasskeySignCount счетчик подписей не вырос: возможно, ключ скопирован
+	ErrPasskeySignCount = apierror.Unauthorized("passkey_sign_count", "passkey signature counter did not increase")
+)
+
+// WebAuthnConfig настройки relying party для passkey
+type WebAuthnConfig struct {
+	// RPID домен, к которому привязываются ключи, например example.com
+	RPID   string
+	RPName string
+	// Origins адреса страниц, с которых разрешены церемонии
+	Origins []string
+}
+
+// RelyingParty описание сервиса для аутентификатора
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// PasskeyUser учетная запись, к которой привязывается ключ
+type PasskeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// PasskeyCreationOptions параметры navigator.credentials.create в JSON
+// формате (PublicKeyCredential.parseCreationOptionsFromJSON)
+type PasskeyCreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   PasskeyUser            `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// PasskeyRequestOptions параметры navigator.credentials.get в JSON формате
+// (PublicKeyCredential.parseRequestOptionsFromJSON)
+type PasskeyRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// PasskeyRegistrationRequest результат navigator.credentials.create
+// (PublicKeyCredential.toJSON) и название ключа для списка в профиле
+type PasskeyRegistrationRequest struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// PasskeyLoginRequest результат navigator.credentials.get
+type PasskeyLoginRequest struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// PasskeyLoginBeginRequest email необязателен: без него браузер предложит
+// ключи, сохраненные на устройстве (discoverable credentials)
+type PasskeyLoginBeginRequest struct {
+	Email string `json:"email"`
+}
+
+// BeginPasskeyRegistration выдает challenge для регистрации нового ключа
+func (s *service) BeginPasskeyRegistration(userID int) (*PasskeyCreationOptions, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.ListPasskeys(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+
+	challenge, err := s.newWebAuthnChallenge(&WebAuthnSession{Ceremony: ceremonyCreate, UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if displayName == "" {
+		displayName = user.Email
+	}
+
+	return &PasskeyCreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: s.webauthn.RPID, Name: s.webauthn.RPName},
+		User: PasskeyUser{
+			ID:          base64.RawURLEncoding.EncodeToString(userHandle(userID)),
+			Name:        user.Email,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: coseES256},
+			{Type: "public-key", Alg: coseEdDSA},
+			{Type: "public-key", Alg: coseRS256},
+		},
+		Timeout:            int(webauthnTimeout.Milliseconds()),
+		ExcludeCredentials: credentialDescriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		// Аттестация производителя не проверяется
+		Attestation: "none",
+	}, nil
+}
+
+// FinishPasskeyRegistration проверяет ответ аутентификатора и сохраняет ключ
+func (s *service) FinishPasskeyRegistration(userID int, req *PasskeyRegistrationRequest) (*PasskeyCredential, error) {
+	clientDataJSON, err := decodeBase64URL(req.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	session, err := s.verifyClientData(clientDataJSON, ceremonyCreate)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, ErrPasskeyChallenge
+	}
+
+	attestationObject, err := decodeBase64URL(req.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	decoded, n, err := decodeCBOR(attestationObject)
+	if err != nil || n != len(attestationObject) {
+		return nil, ErrInvalidPasskey
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidPasskey
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidPasskey
+	}
+
+	authData, err := s.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.Flags&flagAttestedData == 0 || len(authData.CredentialID) == 0 {
+		return nil, ErrInvalidPasskey
+	}
+	if req.RawID != "" {
+		rawID, err := decodeBase64URL(req.RawID)
+		if err != nil || !bytes.Equal(rawID, authData.CredentialID) {
+			return nil, ErrInvalidPasskey
+		}
+	}
+
+	// Сохраняем только ключи с поддерживаемым алгоритмом
+	if _, err := parseCOSEKey(authData.PublicKey); err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+
+	credential := &PasskeyCredential{
+		UserID:       userID,
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		SignCount:    authData.SignCount,
+		Transports:   req.Response.Transports,
+		Name:         name,
+	}
+	if credential.Transports == nil {
+		credential.Transports = []string{}
+	}
+
+	if err := s.repo.CreatePasskey(credential); err != nil {
+		if errors.Is(err, ErrPasskeyExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to save passkey: %w", err)
+	}
+
+	return credential, nil
+}
+
+// BeginPasskeyLogin выдает challenge для входа. Для неизвестного email
+// ответ не отличается от ответа пользователю без ключей
+func (s *service) BeginPasskeyLogin(email string) (*PasskeyRequestOptions, error) {
+	session := &WebAuthnSession{Ceremony: ceremonyGet}
+	allowCredentials := []CredentialDescriptor{}
+
+	if email != "" {
+		user, err := s.repo.GetUserByEmail(email)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		if user != nil {
+			credentials, err := s.repo.ListPasskeys(user.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list passkeys: %w", err)
+			}
+			session.UserID = user.ID
+			allowCredentials = credentialDescriptors(credentials)
+		}
+	}
+
+	challenge, err := s.newWebAuthnChallenge(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyRequestOptions{
+		Challenge:        challenge,
+		Timeout:          int(webauthnTimeout.Milliseconds()),
+		RPID:             s.webauthn.RPID,
+		AllowCredentials: allowCredentials,
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishPasskeyLogin проверяет подпись аутентификатора и возвращает
+// владельца ключа. Ключ с проверкой пользователя заменяет и пароль, и TOTP
+func (s *service) FinishPasskeyLogin(req *PasskeyLoginRequest) (*User, error) {
+	clientDataJSON, err := decodeBase64URL(req.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	// Challenge расходуется при любой попытке
+	session, err := s.verifyClientData(clientDataJSON, ceremonyGet)
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, err := decodeBase64URL(req.RawID)
+	if err != nil || len(rawID) == 0 {
+		return nil, ErrInvalidPasskey
+	}
+
+	credential, err := s.repo.GetPasskey(rawID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+	if credential == nil {
+		return nil, ErrInvalidPasskey
+	}
+	if session.UserID != 0 && session.UserID != credential.UserID {
+		return nil, ErrInvalidPasskey
+	}
+	if req.Response.UserHandle != "" {
+		handle, err := decodeBase64URL(req.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, userHandle(credential.UserID)) {
+			return nil, ErrInvalidPasskey
+		}
+	}
+
+	rawAuthData, err := decodeBase64URL(req.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	authData, err := s.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeBase64URL(req.Response.Signature)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stored passkey: %w", err)
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if !key.verify(signed, signature) {
+		return nil, ErrInvalidPasskey
+	}
+
+	if !signCountIncreased(credential.SignCount, authData.SignCount) {
+		log.Printf("⚠️ Passkey %d of user %d: sign count %d <= %d, possible cloned authenticator",
+			credential.ID, credential.UserID, authData.SignCount, credential.SignCount)
+		return nil, ErrPasskeySignCount
+	}
+
+	if err := s.repo.UpdatePasskeySignCount(credential.ID, authData.SignCount); err != nil {
+		return nil, fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	return s.repo.GetUserByID(credential.UserID)
+}
+
+func (s *service) ListPasskeys(userID int) ([]PasskeyCredential, error) {
+	return s.repo.ListPasskeys(userID)
+}
+
+func (s *service) DeletePasskey(userID, id int) error {
+	deleted, err := s.repo.DeletePasskey(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (s *service) newWebAuthnChallenge(session *WebAuthnSession) (string, error) {
+	challenge, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate challenge: %w", err)
+	}
+
+	if err := s.challenges.SaveChallenge(context.Background(), challenge, session, webauthnTimeout); err != nil {
+		return "", fmt.Errorf("failed to save challenge: %w", err)
+	}
+
+	return challenge, nil
+}
+
+// verifyClientData проверяет тип церемонии и origin и расходует challenge
+func (s *service) verifyClientData(clientDataJSON []byte, ceremony string) (*WebAuthnSession, error) {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	if clientData.Type != ceremony || clientData.CrossOrigin || !s.allowedOrigin(clientData.Origin) {
+		return nil, ErrInvalidPasskey
+	}
+	if clientData.Challenge == "" {
+		return nil, ErrPasskeyChallenge
+	}
+
+	session, err := s.challenges.TakeChallenge(context.Background(), clientData.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	if session == nil || session.Ceremony != ceremony {
+		return nil, ErrPasskeyChallenge
+	}
+
+	return session, nil
+}
+
+func (s *service) allowedOrigin(origin string) bool {
+	for _, allowed := range s.webauthn.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticatorData разобранные данные аутентификатора (WebAuthn §6.1)
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key, только при регистрации
+}
+
+// verifyAuthenticatorData проверяет, что ключ выпущен для нашего RP ID и
+// пользователь подтвердил действие (присутствие и PIN или биометрия)
+func (s *service) verifyAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	authData, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	rpIDHash := sha256.Sum256([]byte(s.webauthn.RPID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return nil, ErrInvalidPasskey
+	}
+	if authData.Flags&flagUserPresent == 0 || authData.Flags&flagUserVerified == 0 {
+		return nil, ErrInvalidPasskey
+	}
+
+	return authData, nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	authData := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if authData.Flags&flagAttestedData != 0 {
+		// aaguid (16) + длина credential id (2) + credential id + COSE_Key
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, errors.New("credential id too short")
+		}
+		authData.CredentialID = append([]byte(nil), rest[:idLen]...)
+		rest = rest[idLen:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %w", err)
+		}
+		authData.PublicKey = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+	}
+
+	if authData.Flags&flagExtensionsData != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extensions: %w", err)
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected trailing authenticator data")
+	}
+
+	return authData, nil
+}
+
+// signCountIncreased проверяет, что счетчик подписей вырос с прошлого входа.
+// Аутентификаторы без счетчика всегда присылают 0
+func signCountIncreased(stored, received uint32) bool {
+	return (received == 0 && stored == 0) || received > stored
+}
+
+// coseKey открытый ключ аутентификатора (RFC 9053)
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parseCOSEKey(data []byte) (*coseKey, error) {
+	decoded, n, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, errors.New("unexpected trailing cose key data")
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("cose key is not a map")
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case alg == coseES256 && kty == 2:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 key")
+		}
+		// Проверяем, что точка лежит на кривой
+		point := append(append([]byte{0x04}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &coseKey{alg: alg, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+	case alg == coseEdDSA && kty == 1:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case alg == coseRS256 && kty == 3:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported cose algorithm %d", alg)
+	}
+}
+
+func (k *coseKey) verify(data, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// userHandle идентификатор пользователя, который аутентификатор хранит
+// вместе с ключом и возвращает при входе без email
+func userHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+func credentialDescriptors(credentials []PasskeyCredential) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(credentials))
+	for _, c := range credentials {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type:       "public-key",
+			ID:         base64.RawURLEncoding.EncodeToString(c.CredentialID),
+			Transports: c.Transports,
+		})
+	}
+	return descriptors
+}
+
+// decodeBase64URL принимает base64url с дополнением и без
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
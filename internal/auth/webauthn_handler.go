@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"auth-user-service/internal/apierror"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errPasskeyLoginFailed  = apierror.Unauthorized("invalid_passkey", "Invalid passkey")
+	errPasskeyLoginExpired = apierror.Unauthorized("passkey_challenge_expired", "Passkey login expired")
+)
+
+func (h *Handler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	options, err := h.service.BeginPasskeyRegistration(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, options, http.StatusOK)
+}
+
+func (h *Handler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req PasskeyRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	credential, err := h.service.FinishPasskeyRegistration(userID, &req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, credential, http.StatusCreated)
+}
+
+func (h *Handler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	credentials, err := h.service.ListPasskeys(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, credentials, http.StatusOK)
+}
+
+func (h *Handler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	if err := h.service.DeletePasskey(userID, id); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req PasskeyLoginBeginRequest
+	// Тело необязательно
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, apierror.ErrInvalidJSON)
+			return
+		}
+	}
+
+	options, err := h.service.BeginPasskeyLogin(req.Email)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, options, http.StatusOK)
+}
+
+// FinishPasskeyLogin завершает вход по passkey и выдает те же токены, что и Login
+func (h *Handler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req PasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	user, err := h.service.FinishPasskeyLogin(&req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidPasskey), errors.Is(err, ErrPasskeySignCount):
+			// При входе неверный ключ — ошибка аутентификации, а не запроса
+			err = errPasskeyLoginFailed
+		case errors.Is(err, ErrPasskeyChallenge):
+			err = errPasskeyLoginExpired
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	tokens, err := h.service.IssueTokens(user, NewClientInfo(r))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeAuthResponse(w, user, tokens, http.StatusOK)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"auth-user-service/internal/redis"
+)
+
+// WebAuthnSession незавершенная церемония регистрации или входа
+type WebAuthnSession struct {
+	Ceremony string `json:"ceremony"` // webauthn.create или webauthn.get
+	UserID   int    `json:"user_id"`  // 0 для входа без указания email
+}
+
+// ChallengeStore хранит выданные challenge до завершения церемонии.
+// Challenge одноразовый: TakeChallenge возвращает сессию и удаляет ее
+type ChallengeStore interface {
+	SaveChallenge(ctx context.Context, challenge string, session *WebAuthnSession, ttl time.Duration) error
+	TakeChallenge(ctx context.Context, challenge string) (*WebAuthnSession, error)
+}
+
+// Redis реализация
+type redisChallengeStore struct {
+	redis RedisClient
+}
+
+func NewRedisChallengeStore(redisClient RedisClient) ChallengeStore {
+	return &redisChallengeStore{redis: redisClient}
+}
+
+func (s *redisChallengeStore) SaveChallenge(ctx context.Context, challenge string, session *WebAuthnSession, ttl time.Duration) error {
+	return s.redis.Set(ctx, "webauthn_challenge:"+challenge, session, ttl)
+}
+
+func (s *redisChallengeStore) TakeChallenge(ctx context.Context, challenge string) (*WebAuthnSession, error) {
+	var session WebAuthnSession
+	err := s.redis.GetDel(ctx, "webauthn_challenge:"+challenge, &session)
+	if errors.Is(err, redis.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// PostgreSQL реализация, используется когда Redis не настроен
+type postgresChallengeStore struct {
+	db *sql.DB
+}
+
+func NewPostgresChallengeStore(db *sql.DB) ChallengeStore {
+	return &postgresChallengeStore{db: db}
+}
+
+func (s *postgresChallengeStore) SaveChallenge(ctx context.Context, challenge string, session *WebAuthnSession, ttl time.Duration) error {
+	// Попутно чистим незавершенные церемонии
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM webauthn_challenges WHERE expires_at < NOW()"); err != nil {
+		return err
+	}
+
+	var userID sql.NullInt64
+	if session.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(session.UserID), Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO webauthn_challenges (challenge, ceremony, user_id, expires_at) VALUES ($1, $2, $3, $4)",
+		challenge, session.Ceremony, userID, time.Now().Add(ttl).UTC(),
+	)
+	return err
+}
+
+func (s *postgresChallengeStore) TakeChallenge(ctx context.Context, challenge string) (*WebAuthnSession, error) {
+	var session WebAuthnSession
+	var userID sql.NullInt64
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM webauthn_challenges
+		 WHERE challenge = $1 AND expires_at > NOW()
+		 RETURNING ceremony, user_id`,
+		challenge,
+	).Scan(&session.Ceremony, &userID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session.UserID = int(userID.Int64)
+	return &session, nil
+}
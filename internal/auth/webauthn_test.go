@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+// Векторы записаны с программного аутентификатора для RP ID localhost:
+// регистрация ES256 ключа (attestation "none") и вход этим ключом и Ed25519
+// ключом по одному и тому же clientDataJSON
+const (
+	testCredentialID = "1a67946786169d8c2f24caad18da1f21"
+
+	testES256Key = "a5010203262001215820207b20f93c110bd3aab5c8f34e4fae4f143e29d81151fa1e3a70" +
+		"e8b8767bdc622258201731ca8bcf130ada5ef8cf246554e93c668faa2df712df6d487b287b6ed4e29e"
+
+	// {"fmt": "none", "attStmt": {}, "authData": rpIdHash, flags, signCount,
+	// aaguid, длина credential id, credential id, COSE_Key}
+	testAttestationObject = "a363666d74646e6f6e656761747453746d74a06861757468446174615894" +
+		"49960de5880e8c687434170f6476605b8fe4aeb9a28632c7995cf3ba831d9763" + "45" + "00000000" +
+		"00000000000000000000000000000000" + "0010" + testCredentialID + testES256Key
+
+	testAssertionAuthData = "49960de5880e8c687434170f6476605b8fe4aeb9a28632c7995cf3ba831d97630500000001"
+
+	testClientDataJSON = `{"type":"webauthn.get","challenge":"dGVzdC1jaGFsbGVuZ2U","origin":"http://localhost:8080","crossOrigin":false}`
+
+	testES256Signature = "304502206daa915a3a4e3feeb02cbd82cd9ffa2ccfc5ff730c78bfc4af358173030822ab" +
+		"02210082136c3ed162d1c460c05047b853da5e617b08b6277ea0a4c6cbb946f73cce44"
+
+	testEdDSAKey = "a4010103272006215820e4ce51eba32a2fe06fe4a9258d5736af83a519cdc62a3c01ef68e5364a377a8d"
+
+	testEdDSASignature = "e5af30f35d19ab1cb1aa138cee239bd4c096d9db2109058b2c54c071c2dbd236" +
+		"61b565e010b6f9d4072291dc46b19d4bb61f53b07bef494e73d34f1de5190d02"
+)
+
+func TestParseAttestation(t *testing.T) {
+	attestationObject := unhex(testAttestationObject)
+	decoded, n, err := decodeCBOR(attestationObject)
+	if err != nil || n != len(attestationObject) {
+		t.Fatalf("decodeCBOR(attestationObject) = %d bytes, %v", n, err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok || attestation["fmt"] != "none" {
+		t.Fatalf("attestationObject = %#v, want fmt none", decoded)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		t.Fatalf("authData is %T, want []byte", attestation["authData"])
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		t.Fatalf("parseAuthenticatorData error: %v", err)
+	}
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		t.Errorf("RPIDHash = %x, want %x", authData.RPIDHash, rpIDHash)
+	}
+	if want := byte(flagUserPresent | flagUserVerified | flagAttestedData); authData.Flags != want {
+		t.Errorf("Flags = %#x, want %#x", authData.Flags, want)
+	}
+	if authData.SignCount != 0 {
+		t.Errorf("SignCount = %d, want 0", authData.SignCount)
+	}
+	if !bytes.Equal(authData.CredentialID, unhex(testCredentialID)) {
+		t.Errorf("CredentialID = %x, want %s", authData.CredentialID, testCredentialID)
+	}
+	if !bytes.Equal(authData.PublicKey, unhex(testES256Key)) {
+		t.Errorf("PublicKey = %x, want %s", authData.PublicKey, testES256Key)
+	}
+
+	key, err := parseCOSEKey(authData.PublicKey)
+	if err != nil {
+		t.Fatalf("parseCOSEKey error: %v", err)
+	}
+	if key.alg != coseES256 {
+		t.Errorf("alg = %d, want %d", key.alg, coseES256)
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	rawAuthData := unhex(testAssertionAuthData)
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		t.Fatalf("parseAuthenticatorData error: %v", err)
+	}
+	if authData.SignCount != 1 || authData.CredentialID != nil || authData.PublicKey != nil {
+		t.Errorf("authData = %+v, want sign count 1 without credential", authData)
+	}
+
+	clientDataHash := sha256.Sum256([]byte(testClientDataJSON))
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+
+	tests := []struct {
+		name      string
+		key       string
+		signature string
+	}{
+		{"ES256", testES256Key, testES256Signature},
+		{"EdDSA", testEdDSAKey, testEdDSASignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseCOSEKey(unhex(tt.key))
+			if err != nil {
+				t.Fatalf("parseCOSEKey error: %v", err)
+			}
+			signature := unhex(tt.signature)
+			if !key.verify(signed, signature) {
+				t.Error("verify = false, want true")
+			}
+
+			// Другие данные или поврежденная подпись не проходят
+			tampered := append([]byte{}, signed...)
+			tampered[32] ^= flagUserVerified
+			if key.verify(tampered, signature) {
+				t.Error("verify of tampered data = true, want false")
+			}
+			signature[len(signature)-1] ^= 1
+			if key.verify(signed, signature) {
+				t.Error("verify of tampered signature = true, want false")
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorDataMalformed(t *testing.T) {
+	// rpIdHash (32) + flags + signCount (4) без attested credential data
+	header := func(flags string) string {
+		return testAssertionAuthData[:64] + flags + "00000001"
+	}
+	// aaguid (16) + длина credential id (2)
+	attested := strings.Repeat("00", 16) + "0010" + testCredentialID
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"truncated header", testAssertionAuthData[:72]},
+		{"trailing data", testAssertionAuthData + "00"},
+		{"attested flag without data", header("45")},
+		{"truncated aaguid", header("45") + strings.Repeat("00", 10)},
+		{"credential id longer than data", header("45") + strings.Repeat("00", 16) + "ffff" + testCredentialID},
+		{"missing public key", header("45") + attested},
+		{"truncated public key", header("45") + attested + testES256Key[:100]},
+		{"too deep public key", header("45") + attested + strings.Repeat("81", cborMaxDepth+1) + "01"},
+		{"trailing data after public key", header("45") + attested + testES256Key + "00"},
+		{"extensions flag without data", header("85")},
+		{"truncated extensions", header("85") + "a1"},
+		{"trailing data after extensions", header("85") + "a0" + "00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseAuthenticatorData(unhex(tt.data)); err == nil {
+				t.Errorf("parseAuthenticatorData = %+v, want error", got)
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorDataExtensions(t *testing.T) {
+	// {"credProtect": 2}
+	data := unhex(testAssertionAuthData[:64] + "85" + "00000007" + "a16b6372656450726f7465637402")
+	authData, err := parseAuthenticatorData(data)
+	if err != nil {
+		t.Fatalf("parseAuthenticatorData error: %v", err)
+	}
+	if authData.SignCount != 7 {
+		t.Errorf("SignCount = %d, want 7", authData.SignCount)
+	}
+}
+
+func TestParseCOSEKeyMalformed(t *testing.T) {
+	coord := func(b string) string { return "5820" + strings.Repeat(b, 32) }
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"empty", ""},
+		{"not a map", "83010203"},
+		{"truncated", testES256Key[:60]},
+		{"trailing data", testES256Key + "00"},
+		{"too deep", strings.Repeat("a101", cborMaxDepth+1) + "01"},
+		{"missing algorithm", "a10102"},
+		{"unsupported algorithm", "a201020338" + "22"},
+		{"algorithm and key type mismatch", "a201010326"},
+		{"ES256 wrong curve", "a5010203262002" + "21" + coord("01") + "22" + coord("02")},
+		{"ES256 short coordinate", "a5010203262001" + "2141" + "01" + "22" + coord("02")},
+		{"ES256 point not on curve", "a5010203262001" + "21" + coord("01") + "22" + coord("02")},
+		{"EdDSA wrong curve", "a4010103272001" + "21" + coord("00")},
+		{"EdDSA short key", "a4010103272006" + "2141" + "00"},
+		{"RS256 short modulus", "a40103033901002041ff2143010001"},
+		{"RS256 oversized exponent", "a401030339010020590100" + strings.Repeat("ff", 256) + "2145" + "0101010101"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseCOSEKey(unhex(tt.key)); err == nil {
+				t.Errorf("parseCOSEKey = %+v, want error", got)
+			}
+		})
+	}
+}
+
+func TestSignCountIncreased(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   uint32
+		received uint32
+		want     bool
+	}{
+		{"authenticator without counter", 0, 0, true},
+		{"first use", 0, 1, true},
+		{"increased", 5, 6, true},
+		{"jumped", 5, 100, true},
+		{"repeated", 5, 5, false},
+		{"went back", 5, 4, false},
+		{"reset to zero", 5, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signCountIncreased(tt.stored, tt.received); got != tt.want {
+				t.Errorf("signCountIncreased(%d, %d) = %v, want %v", tt.stored, tt.received, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +14,18 @@ type Config struct {
 	Redis       RedisConfig
 	JWT         JWTConfig
 	CORS        CORSConfig
+	OIDC        OIDCConfig
+	Federation  FederationConfig
+	MFA         MFAConfig
+	WebAuthn    WebAuthnConfig
+	Mail        MailConfig
+	Password    PasswordConfig
+	EmailVerify EmailVerificationConfig
+	MagicLink   MagicLinkConfig
+	Lockout     LockoutConfig
+	Idempotency IdempotencyConfig
+	// AdminEmails пользователи, которым при запуске выдается роль admin
+	AdminEmails []string
 }
 
 type ServerConfig struct {
@@ -32,7 +46,140 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret string
+	Secret          string
+	SecretExpiresAt string
+	SigningKeys     []string
+	ActiveKeyID     string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type OIDCConfig struct {
+	Issuer      string
+	AuthCodeTTL time.Duration
+}
+
+// FederationConfig внешние провайдеры входа. Список задается в
+// IDP_PROVIDERS, настройки каждого — в переменных IDP_<NAME>_*
+type FederationConfig struct {
+	CallbackBaseURL string
+	Providers       []IdentityProviderConfig
+}
+
+type IdentityProviderConfig struct {
+	Name         string
+	Type         string
+	ClientID     string
+	ClientSecret string
+	Issuer       string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	EmailsURL    string
+	Scopes       []string
+}
+
+type MFAConfig struct {
+	// Issuer название сервиса в приложении-аутентификаторе
+	Issuer string
+}
+
+// WebAuthnConfig relying party для passkey. RPID должен совпадать с доменом
+// страниц из Origins или быть его родительским доменом
+type WebAuthnConfig struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+// MailConfig отправка писем. Driver: smtp, file (письма сохраняются в Dir)
+// или log (письма только пишутся в лог)
+type MailConfig struct {
+	Driver       string
+	From         string
+	Dir          string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+type PasswordConfig struct {
+	// ResetURL страница фронтенда для ввода нового пароля, токен
+	// добавляется параметром token
+	ResetURL string
+	ResetTTL time.Duration
+	// Политика паролей. MaxLength в байтах, bcrypt учитывает не больше 72.
+	// BannedFile дополняет встроенный список распространенных паролей
+	MinLength  int
+	MaxLength  int
+	MinClasses int
+	BannedFile string
+	// BreachedFile база утекших паролей в формате Have I Been Pwned (файл или
+	// каталог с файлами по префиксам хэша), пусто отключает проверку.
+	// BreachedMode: reject (отклонять) или warn (принимать с предупреждением)
+	BreachedFile string
+	BreachedMode string
+	// HashAlgorithm алгоритм новых хэшей: argon2id или bcrypt. Хэши другого
+	// алгоритма или с более слабыми параметрами пересчитываются при входе.
+	// Argon2Memory в KiB. HashConcurrency сколько хэшей вычисляется
+	// одновременно, 0 — по числу CPU
+	HashAlgorithm     string
+	BcryptCost        int
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+	HashConcurrency   int
+}
+
+// MagicLinkConfig вход без пароля по ссылке из письма. AutoRegister
+// создает пользователя, если email еще не зарегистрирован
+type MagicLinkConfig struct {
+	URL          string
+	TTL          time.Duration
+	AutoRegister bool
+}
+
+// IdempotencyConfig ключи идемпотентности. TTL сколько хранится ответ,
+// LockTimeout сколько повтор ждет завершения первого запроса
+type IdempotencyConfig struct {
+	TTL         time.Duration
+	LockTimeout time.Duration
+}
+
+// EmailVerificationConfig подтверждение email. Required перечисляет, что
+// недоступно без подтверждения: login, orders
+type EmailVerificationConfig struct {
+	URL            string
+	TTL            time.Duration
+	ResendInterval time.Duration
+	Required       []string
+	// ChangeURL адрес из письма для подтверждения нового email
+	ChangeURL string
+}
+
+// RequiredFor сообщает, требуется ли подтвержденный email для действия
+func (c *EmailVerificationConfig) RequiredFor(action string) bool {
+	for _, a := range c.Required {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// LockoutConfig защита от перебора паролей: после FreeAttempts неудач
+// перед каждой попыткой нужно ждать BaseDelay, удваивающийся до MaxDelay,
+// после MaxAttempts вход блокируется на Duration. Для IP пороги выше, так
+// как за одним адресом может быть много пользователей
+type LockoutConfig struct {
+	FreeAttempts   int
+	MaxAttempts    int
+	IPFreeAttempts int
+	IPMaxAttempts  int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	Duration       time.Duration
 }
 
 type CORSConfig struct {
@@ -57,11 +204,85 @@ func Load() *Config {
 			URL: getEnv("REDIS_URL", ""),
 		},
 		JWT: JWTConfig{
-			Secret: getEnv("JWT_SECRET", ""),
+			Secret:          getEnv("JWT_SECRET", ""),
+			SecretExpiresAt: getEnv("JWT_SECRET_EXPIRES_AT", ""),
+			SigningKeys:     getList("JWT_SIGNING_KEYS"),
+			ActiveKeyID:     getEnv("JWT_ACTIVE_KEY_ID", ""),
+			AccessTokenTTL:  getDuration("JWT_ACCESS_TTL", 15*time.Minute),
+			RefreshTokenTTL: getDuration("JWT_REFRESH_TTL", 30*24*time.Hour),
 		},
 		CORS: CORSConfig{
 			AllowedOrigins: getCORSAllowedOrigins(),
 		},
+		OIDC: OIDCConfig{
+			Issuer:      getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080")),
+			AuthCodeTTL: getDuration("OIDC_AUTH_CODE_TTL", time.Minute),
+		},
+		Federation: FederationConfig{
+			CallbackBaseURL: getEnv("IDP_CALLBACK_BASE_URL", getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080"))),
+			Providers:       getIdentityProviders(),
+		},
+		MFA: MFAConfig{
+			Issuer: getEnv("MFA_ISSUER", "Auth User Service"),
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:    getEnv("WEBAUTHN_RP_ID", "localhost"),
+			RPName:  getEnv("WEBAUTHN_RP_NAME", getEnv("MFA_ISSUER", "Auth User Service")),
+			Origins: getWebAuthnOrigins(),
+		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "no-reply@localhost"),
+			Dir:          getEnv("MAIL_DIR", "./mail"),
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		},
+		Password: PasswordConfig{
+			ResetURL:   getEnv("PASSWORD_RESET_URL", getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080"))+"/reset-password"),
+			ResetTTL:   getDuration("PASSWORD_RESET_TTL", time.Hour),
+			MinLength:  getInt("PASSWORD_MIN_LENGTH", 8),
+			MaxLength:  getInt("PASSWORD_MAX_LENGTH", 72),
+			MinClasses: getInt("PASSWORD_MIN_CLASSES", 3),
+			BannedFile: getEnv("PASSWORD_BANNED_FILE", ""),
+
+			BreachedFile: getEnv("PASSWORD_BREACHED_FILE", ""),
+			BreachedMode: getEnv("PASSWORD_BREACHED_MODE", "reject"),
+
+			HashAlgorithm:     getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+			BcryptCost:        getInt("PASSWORD_BCRYPT_COST", 10),
+			Argon2Memory:      getInt("PASSWORD_ARGON2_MEMORY", 64*1024),
+			Argon2Iterations:  getInt("PASSWORD_ARGON2_ITERATIONS", 3),
+			Argon2Parallelism: getInt("PASSWORD_ARGON2_PARALLELISM", 2),
+			HashConcurrency:   getInt("PASSWORD_HASH_CONCURRENCY", 0),
+		},
+		EmailVerify: EmailVerificationConfig{
+			URL:            getEnv("EMAIL_VERIFICATION_URL", getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080"))+"/auth/verify-email"),
+			ChangeURL:      getEnv("EMAIL_CHANGE_URL", getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080"))+"/auth/email/confirm"),
+			TTL:            getDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+			ResendInterval: getDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute),
+			Required:       getList("EMAIL_VERIFICATION_REQUIRED"),
+		},
+		MagicLink: MagicLinkConfig{
+			URL:          getEnv("MAGIC_LINK_URL", getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080"))+"/auth/magic-link/verify"),
+			TTL:          getDuration("MAGIC_LINK_TTL", 15*time.Minute),
+			AutoRegister: getBool("MAGIC_LINK_AUTO_REGISTER", false),
+		},
+		Lockout: LockoutConfig{
+			FreeAttempts:   getInt("LOGIN_FREE_ATTEMPTS", 3),
+			MaxAttempts:    getInt("LOGIN_MAX_ATTEMPTS", 10),
+			IPFreeAttempts: getInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+			IPMaxAttempts:  getInt("LOGIN_IP_MAX_ATTEMPTS", 100),
+			BaseDelay:      getDuration("LOGIN_BASE_DELAY", time.Second),
+			MaxDelay:       getDuration("LOGIN_MAX_DELAY", time.Minute),
+			Duration:       getDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:         getDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+			LockTimeout: getDuration("IDEMPOTENCY_LOCK_TIMEOUT", 10*time.Second),
+		},
+		AdminEmails: getList("ADMIN_EMAILS"),
 	}
 }
 
@@ -76,6 +297,75 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getIdentityProviders() []IdentityProviderConfig {
+	var providers []IdentityProviderConfig
+	for _, name := range getList("IDP_PROVIDERS") {
+		prefix := "IDP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		providers = append(providers, IdentityProviderConfig{
+			Name:         name,
+			Type:         getEnv(prefix+"TYPE", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			AuthURL:      getEnv(prefix+"AUTH_URL", ""),
+			TokenURL:     getEnv(prefix+"TOKEN_URL", ""),
+			UserInfoURL:  getEnv(prefix+"USERINFO_URL", ""),
+			EmailsURL:    getEnv(prefix+"EMAILS_URL", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "")),
+		})
+	}
+	return providers
+}
+
+func getWebAuthnOrigins() []string {
+	if origins := getList("WEBAUTHN_ORIGINS"); len(origins) > 0 {
+		return origins
+	}
+	return []string{getEnv("OIDC_ISSUER", "http://localhost:"+getEnv("PORT", "8080"))}
+}
+
+func getList(key string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
 func getCORSAllowedOrigins() []string {
 	// Allow all origins by default
 	corsOrigins := getEnv("CORS_ALLOWED_ORIGINS", "")
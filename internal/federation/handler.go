@@ -0,0 +1,153 @@
+package federation
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	service      Service
+	secureCookie bool
+}
+
+func NewHandler(service Service, secureCookie bool) *Handler {
+	return &Handler{service: service, secureCookie: secureCookie}
+}
+
+var (
+	errLoginExpired        = apierror.Validation("login_session_expired", "Login session expired")
+	errInvalidState        = apierror.Validation("invalid_state", "Invalid state")
+	errProviderUnavailable = apierror.New(apierror.KindUpstream, "provider_unavailable", "Identity provider unavailable")
+	errProviderFailed      = apierror.New(apierror.KindUpstream, "provider_failed", "Failed to sign in with identity provider")
+)
+
+// stateCookie хранит state и PKCE verifier между перенаправлением к
+// провайдеру и возвратом на callback
+const stateCookie = "federation_state"
+
+func (h *Handler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, map[string][]string{"providers": h.service.Providers()}, http.StatusOK)
+}
+
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	providerName := chi.URLParam(r, "provider")
+
+	state, err := randomString(24)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	codeVerifier, err := randomString(32)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	authURL, err := h.service.AuthCodeURL(providerName, state, codeVerifier, state)
+	if err != nil {
+		if !errors.Is(err, ErrUnknownProvider) {
+			log.Printf("Error starting federated login: %v", err)
+			err = errProviderUnavailable
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state + "." + codeVerifier,
+		Path:     "/auth/providers/" + providerName,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   h.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (h *Handler) Callback(w http.ResponseWriter, r *http.Request) {
+	providerName := chi.URLParam(r, "provider")
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		h.writeError(w, errLoginExpired)
+		return
+	}
+
+	// Cookie одноразовая
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    "",
+		Path:     "/auth/providers/" + providerName,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	state, codeVerifier, ok := strings.Cut(cookie.Value, ".")
+	query := r.URL.Query()
+	if !ok || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		h.writeError(w, errInvalidState)
+		return
+	}
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		h.writeError(w, apierror.Unauthorized("provider_error", "Identity provider returned error: "+providerErr))
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		h.writeError(w, apierror.MissingField("code"))
+		return
+	}
+
+	result, err := h.service.Complete(providerName, code, codeVerifier, auth.NewClientInfo(r))
+	if err != nil {
+		// Ошибки без кода считаются сбоем провайдера, а не сервиса
+		var apiErr *apierror.Error
+		if !errors.As(err, &apiErr) {
+			log.Printf("Error completing federated login: %v", err)
+			err = errProviderFailed
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, result.Response(), http.StatusOK)
+}
+
+// Вспомогательные методы
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// writeError отвечает ошибкой в формате application/problem+json
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	apierror.Write(w, err)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
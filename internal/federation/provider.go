@@ -0,0 +1,302 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Типы провайдеров
+const (
+	TypeOIDC   = "oidc"
+	TypeGoogle = "google"
+	TypeGitHub = "github"
+)
+
+// ProviderConfig настройки внешнего провайдера. Любой адрес можно
+// переопределить, например, чтобы указать локальный тестовый провайдер
+type ProviderConfig struct {
+	Name         string
+	Type         string
+	ClientID     string
+	ClientSecret string
+	Issuer       string // для generic OIDC: адреса берутся из discovery, если не заданы явно
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	EmailsURL    string // только GitHub
+	Scopes       []string
+}
+
+// Identity учетная запись пользователя у внешнего провайдера
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+type provider struct {
+	cfg        ProviderConfig
+	httpClient *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+}
+
+func newProvider(cfg ProviderConfig, httpClient *http.Client) (*provider, error) {
+	if cfg.Type == "" {
+		cfg.Type = cfg.Name
+	}
+
+	switch cfg.Type {
+	case TypeGoogle:
+		setDefault(&cfg.AuthURL, "https://accounts.google.com/o/oauth2/v2/auth")
+		setDefault(&cfg.TokenURL, "https://oauth2.googleapis.com/token")
+		setDefault(&cfg.UserInfoURL, "https://openidconnect.googleapis.com/v1/userinfo")
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+	case TypeGitHub:
+		setDefault(&cfg.AuthURL, "https://github.com/login/oauth/authorize")
+		setDefault(&cfg.TokenURL, "https://github.com/login/oauth/access_token")
+		setDefault(&cfg.UserInfoURL, "https://api.github.com/user")
+		setDefault(&cfg.EmailsURL, "https://api.github.com/user/emails")
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"read:user", "user:email"}
+		}
+	case TypeOIDC:
+		if cfg.Issuer == "" && (cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.UserInfoURL == "") {
+			return nil, fmt.Errorf("provider %q: issuer or explicit endpoints are required", cfg.Name)
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+	default:
+		return nil, fmt.Errorf("provider %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("provider %q: client id is required", cfg.Name)
+	}
+
+	return &provider{cfg: cfg, httpClient: httpClient}, nil
+}
+
+func setDefault(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
+
+// discover дополняет незаданные адреса из /.well-known/openid-configuration
+func (p *provider) discover(ctx context.Context) error {
+	if p.cfg.Type != TypeOIDC || p.cfg.Issuer == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered || (p.cfg.AuthURL != "" && p.cfg.TokenURL != "" && p.cfg.UserInfoURL != "") {
+		return nil
+	}
+
+	var doc struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	discoveryURL := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, "", &doc); err != nil {
+		// Не запоминаем ошибку, чтобы повторить discovery при следующем входе
+		return fmt.Errorf("discovery failed: %w", err)
+	}
+
+	setDefault(&p.cfg.AuthURL, doc.AuthorizationEndpoint)
+	setDefault(&p.cfg.TokenURL, doc.TokenEndpoint)
+	setDefault(&p.cfg.UserInfoURL, doc.UserInfoEndpoint)
+	p.discovered = true
+
+	return nil
+}
+
+func (p *provider) authCodeURL(redirectURI, state, codeChallenge, nonce string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if p.cfg.Type != TypeGitHub {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(p.cfg.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.cfg.AuthURL + separator + params.Encode()
+}
+
+// exchange обменивает код на access токен провайдера
+func (p *provider) exchange(ctx context.Context, redirectURI, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+
+	return token.AccessToken, nil
+}
+
+// identity загружает данные пользователя по access токену провайдера
+func (p *provider) identity(ctx context.Context, accessToken string) (*Identity, error) {
+	if p.cfg.Type == TypeGitHub {
+		return p.githubIdentity(ctx, accessToken)
+	}
+
+	var info struct {
+		Subject       string      `json:"sub"`
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"`
+		GivenName     string      `json:"given_name"`
+		FamilyName    string      `json:"family_name"`
+	}
+	if err := p.getJSON(ctx, p.cfg.UserInfoURL, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	if info.Subject == "" {
+		return nil, errors.New("userinfo response has no sub")
+	}
+
+	return &Identity{
+		Subject:       info.Subject,
+		Email:         strings.ToLower(info.Email),
+		EmailVerified: parseBool(info.EmailVerified),
+		FirstName:     info.GivenName,
+		LastName:      info.FamilyName,
+	}, nil
+}
+
+func (p *provider) githubIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.getJSON(ctx, p.cfg.UserInfoURL, accessToken, &user); err != nil {
+		return nil, fmt.Errorf("user request failed: %w", err)
+	}
+	if user.ID == 0 {
+		return nil, errors.New("user response has no id")
+	}
+
+	identity := &Identity{Subject: strconv.FormatInt(user.ID, 10)}
+	identity.FirstName, identity.LastName, _ = strings.Cut(user.Name, " ")
+	if identity.FirstName == "" {
+		identity.FirstName = user.Login
+	}
+
+	// Публичный email в профиле может быть не подтвержден, берем основной из /user/emails
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, p.cfg.EmailsURL, accessToken, &emails); err != nil {
+		return nil, fmt.Errorf("emails request failed: %w", err)
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = strings.ToLower(e.Email)
+			identity.EmailVerified = e.Verified
+			break
+		}
+	}
+
+	return identity, nil
+}
+
+func (p *provider) getJSON(ctx context.Context, endpoint, accessToken string, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return p.doJSON(req, dest)
+}
+
+func (p *provider) doJSON(req *http.Request, dest interface{}) error {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 500 || (resp.StatusCode >= 400 && !json.Valid(body)) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(body, dest)
+}
+
+// parseBool учитывает провайдеров, которые отдают email_verified строкой
+func parseBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
+
+func defaultHTTPClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
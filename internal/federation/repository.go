@@ -0,0 +1,55 @@
+package federation
+
+import (
+	"database/sql"
+	"errors"
+)
+
+type Repository interface {
+	FindUserID(provider, subject string) (int, error)
+	LinkIdentity(userID int, provider, subject, email string) error
+	TouchIdentity(provider, subject, email string) error
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return &repository{db: db}
+}
+
+// FindUserID возвращает id пользователя, привязанного к внешней учетной
+// записи, или 0, если привязки нет
+func (r *repository) FindUserID(provider, subject string) (int, error) {
+	var userID int
+	err := r.db.QueryRow(
+		"SELECT user_id FROM linked_identities WHERE provider = $1 AND subject = $2",
+		provider, subject,
+	).Scan(&userID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (r *repository) LinkIdentity(userID int, provider, subject, email string) error {
+	_, err := r.db.Exec(
+		"INSERT INTO linked_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, provider, subject, email,
+	)
+	return err
+}
+
+func (r *repository) TouchIdentity(provider, subject, email string) error {
+	_, err := r.db.Exec(
+		"UPDATE linked_identities SET email = $1, last_login_at = NOW() WHERE provider = $2 AND subject = $3",
+		email, provider, subject,
+	)
+	return err
+}
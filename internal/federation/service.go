@@ -0,0 +1,188 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/auth"
+)
+
+type Service interface {
+	Providers() []string
+	AuthCodeURL(providerName, state, codeVerifier, nonce string) (string, error)
+	Complete(providerName, code, codeVerifier string, client auth.ClientInfo) (*auth.LoginResult, error)
+}
+
+var (
+	ErrUnknownProvider = apierror.NotFound("unknown_provider", "Unknown identity provider")
+	// ErrEmailNotVerified провайдер не подтвердил владение email, поэтому
+	// нельзя ни связать учетные записи, ни занять email новой учетной записью
+	ErrEmailNotVerified = apierror.Conflict("provider_email_not_verified", "Email is not verified by the identity provider")
+	ErrNoEmail          = apierror.New(apierror.KindUnprocessable, "provider_no_email", "Identity provider did not share an email")
+)
+
+// Config настройки входа через внешних провайдеров
+type Config struct {
+	// CallbackBaseURL внешний адрес сервиса для redirect_uri
+	CallbackBaseURL string
+	Providers       []ProviderConfig
+}
+
+type service struct {
+	repo            Repository
+	authService     auth.Service
+	providers       map[string]*provider
+	callbackBaseURL string
+}
+
+func NewService(repo Repository, authService auth.Service, cfg Config) (Service, error) {
+	s := &service{
+		repo:            repo,
+		authService:     authService,
+		providers:       make(map[string]*provider),
+		callbackBaseURL: strings.TrimSuffix(cfg.CallbackBaseURL, "/"),
+	}
+
+	httpClient := defaultHTTPClient()
+	for _, providerCfg := range cfg.Providers {
+		p, err := newProvider(providerCfg, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		s.providers[providerCfg.Name] = p
+	}
+
+	return s, nil
+}
+
+func (s *service) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *service) redirectURI(providerName string) string {
+	return s.callbackBaseURL + "/auth/providers/" + providerName + "/callback"
+}
+
+// AuthCodeURL возвращает адрес, на который перенаправляется пользователь
+func (s *service) AuthCodeURL(providerName, state, codeVerifier, nonce string) (string, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	codeChallenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return p.authCodeURL(s.redirectURI(providerName), state, codeChallenge, nonce), nil
+}
+
+// Complete обменивает код провайдера на его учетную запись, находит или
+// создает связанного пользователя и завершает вход
+func (s *service) Complete(providerName, code, codeVerifier string, client auth.ClientInfo) (*auth.LoginResult, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := p.exchange(ctx, s.redirectURI(providerName), code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := p.identity(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.resolveUser(providerName, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	// Второй фактор требуется и при входе через внешнего провайдера
+	return s.authService.CompleteLogin(user, client)
+}
+
+// resolveUser находит пользователя по привязке (provider, subject). Без
+// привязки связывает учетную запись с пользователем с тем же email или
+// регистрирует нового — в обоих случаях только с подтвержденным email.
+// Учетная запись с неподтвержденным email связывается только после сброса
+// ее способов входа
+func (s *service) resolveUser(providerName string, identity *Identity) (*auth.User, error) {
+	userID, err := s.repo.FindUserID(providerName, identity.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find linked identity: %w", err)
+	}
+
+	if userID != 0 {
+		if err := s.repo.TouchIdentity(providerName, identity.Subject, identity.Email); err != nil {
+			log.Printf("⚠️ Failed to update linked identity: %v", err)
+		}
+		return s.authService.GetUserByID(userID)
+	}
+
+	if identity.Email == "" {
+		return nil, ErrNoEmail
+	}
+	if !identity.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err := s.authService.GetUserByEmail(identity.Email)
+	if err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user == nil {
+		user, err = s.authService.RegisterExternal(identity.Email, identity.FirstName, identity.LastName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register user: %w", err)
+		}
+	} else if user.EmailVerifiedAt == nil {
+		// Неподтвержденную учетную запись мог заранее зарегистрировать кто
+		// угодно, кто знал адрес. Ее пароль и другие способы входа
+		// сбрасываются, иначе этот человек получил бы доступ к аккаунту
+		// владельца email
+		if err := s.authService.ResetCredentials(user); err != nil {
+			return nil, err
+		}
+		// Провайдер подтвердил этот email. Новая учетная запись выше
+		// создается уже подтвержденной
+		if err := s.authService.ConfirmEmail(user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.LinkIdentity(user.ID, providerName, identity.Subject, identity.Email); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	return user, nil
+}
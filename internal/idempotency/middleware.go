@@ -0,0 +1,205 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"auth-user-service/internal/apierror"
+)
+
+// HeaderKey заголовок, которым клиент помечает повторяемый запрос
+const HeaderKey = "Idempotency-Key"
+
+// HeaderReplayed ставится на ответ, взятый из сохраненного результата
+const HeaderReplayed = "Idempotent-Replayed"
+
+const (
+	maxKeyLength = 255
+	maxBodySize  = 1 << 20
+	pollInterval = 100 * time.Millisecond
+)
+
+var (
+	ErrInvalidKey   = apierror.Validation("invalid_idempotency_key", "Idempotency-Key must be at most 255 characters")
+	ErrKeyReused    = apierror.New(apierror.KindUnprocessable, "idempotency_key_reused", "Idempotency-Key was already used with a different request")
+	ErrKeyInFlight  = apierror.Conflict("idempotency_key_in_flight", "A request with this Idempotency-Key is still being processed")
+	ErrBodyTooLarge = apierror.Validation("request_too_large", "Request body is too large")
+)
+
+// Middleware делает POST запросы с заголовком Idempotency-Key повторяемыми.
+// Первый запрос с ключом выполняется, его ответ сохраняется на ttl, и
+// повтор с тем же телом получает этот ответ, не выполняясь заново. Повтор,
+// пришедший, пока первый запрос еще выполняется, ждет его завершения, но не
+// дольше lockTimeout
+type Middleware struct {
+	store       Store
+	ttl         time.Duration
+	lockTimeout time.Duration
+}
+
+func NewMiddleware(store Store, ttl, lockTimeout time.Duration) *Middleware {
+	return &Middleware{store: store, ttl: ttl, lockTimeout: lockTimeout}
+}
+
+// Handler middleware для маршрутов за AuthMiddleware: ключи хранятся
+// отдельно для каждого пользователя
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			apierror.Write(w, ErrInvalidKey)
+			return
+		}
+
+		userID, ok := r.Context().Value("userID").(int)
+		if !ok {
+			apierror.Write(w, apierror.ErrUnauthenticated)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			apierror.Write(w, ErrBodyTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+
+		lockID, err := newLockID()
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		lock := &Record{RequestHash: hash, LockID: lockID}
+
+		record, err := m.reserve(r.Context(), userID, key, lock)
+		if err != nil {
+			apierror.Write(w, err)
+			return
+		}
+		if record != nil {
+			replay(w, record)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		// Результат сохраняем, даже если клиент уже отключился: именно
+		// тогда он пришлет повтор
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.status >= http.StatusInternalServerError {
+			if err := m.store.Release(ctx, userID, key, lock); err != nil {
+				log.Printf("Error releasing idempotency key: %v", err)
+			}
+			return
+		}
+
+		saved, err := m.store.Complete(ctx, userID, key, lock, &Record{
+			RequestHash: hash,
+			Status:      recorder.status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}, m.ttl)
+		if err != nil {
+			log.Printf("Error saving idempotent response: %v", err)
+		} else if !saved {
+			log.Printf("⚠️ Idempotency lock of user %d expired before the response was saved", userID)
+		}
+	})
+}
+
+// reserve занимает ключ или возвращает сохраненный ответ. Пока ключ занят
+// другим запросом, ждет его завершения
+func (m *Middleware) reserve(ctx context.Context, userID int, key string, lock *Record) (*Record, error) {
+	deadline := time.Now().Add(m.lockTimeout)
+	for {
+		record, err := m.store.Reserve(ctx, userID, key, lock, m.lockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, nil
+		}
+		if record.RequestHash != lock.RequestHash {
+			return nil, ErrKeyReused
+		}
+		if record.Status != 0 {
+			return record, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrKeyInFlight
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// newLockID случайный идентификатор блокировки ключа
+func newLockID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// requestHash отпечаток запроса: один ключ нельзя использовать для разных
+// запросов
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, record *Record) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(record.Status)
+	if _, err := w.Write(record.Body); err != nil {
+		log.Printf("Error writing idempotent response: %v", err)
+	}
+}
+
+// responseRecorder передает ответ клиенту и запоминает его копию
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,163 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/redis"
+)
+
+// Record запрос, выполненный с ключом идемпотентности. Пока первый запрос
+// выполняется, Status равен нулю, а LockID отличает его от запроса, который
+// займет ключ после истечения блокировки
+type Record struct {
+	RequestHash string `json:"request_hash"`
+	LockID      string `json:"lock_id,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// Store хранит ключи идемпотентности. Ключи разных пользователей не
+// пересекаются
+type Store interface {
+	// Reserve занимает ключ записью lock на время выполнения запроса, но не
+	// дольше lockTTL. Если ключ уже занят, возвращает его запись, иначе nil
+	Reserve(ctx context.Context, userID int, key string, lock *Record, lockTTL time.Duration) (*Record, error)
+	// Complete сохраняет ответ на ttl, если ключ все еще занят записью lock.
+	// Возвращает false, если блокировка истекла и ключ занял другой запрос
+	Complete(ctx context.Context, userID int, key string, lock, record *Record, ttl time.Duration) (bool, error)
+	// Release освобождает ключ, если запрос не удался и его можно повторить.
+	// Ключ, который уже занят другой записью, не трогается
+	Release(ctx context.Context, userID int, key string, lock *Record) error
+}
+
+type RedisClient interface {
+	Get(ctx context.Context, key string, dest interface{}) error
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
+	SetIfEqual(ctx context.Context, key string, expected, value interface{}, expiration time.Duration) (bool, error)
+	DeleteIfEqual(ctx context.Context, key string, value interface{}) (bool, error)
+}
+
+// Redis реализация
+type redisStore struct {
+	redis RedisClient
+}
+
+func NewRedisStore(redisClient RedisClient) Store {
+	return &redisStore{redis: redisClient}
+}
+
+func redisKey(userID int, key string) string {
+	return fmt.Sprintf("idempotency:%d:%s", userID, key)
+}
+
+func (s *redisStore) Reserve(ctx context.Context, userID int, key string, lock *Record, lockTTL time.Duration) (*Record, error) {
+	// Ключ может истечь между SetNX и Get, тогда пробуем занять его снова
+	for range 3 {
+		ok, err := s.redis.SetNX(ctx, redisKey(userID, key), lock, lockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+
+		var record Record
+		err = s.redis.Get(ctx, redisKey(userID, key), &record)
+		if errors.Is(err, redis.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}
+	return nil, errors.New("idempotency key keeps expiring")
+}
+
+func (s *redisStore) Complete(ctx context.Context, userID int, key string, lock, record *Record, ttl time.Duration) (bool, error) {
+	return s.redis.SetIfEqual(ctx, redisKey(userID, key), lock, record, ttl)
+}
+
+func (s *redisStore) Release(ctx context.Context, userID int, key string, lock *Record) error {
+	// Блокировка могла истечь, и ключ уже занял следующий запрос
+	_, err := s.redis.DeleteIfEqual(ctx, redisKey(userID, key), lock)
+	return err
+}
+
+// PostgreSQL реализация, используется когда Redis не настроен
+type postgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) Store {
+	return &postgresStore{db: db}
+}
+
+func (s *postgresStore) Reserve(ctx context.Context, userID int, key string, lock *Record, lockTTL time.Duration) (*Record, error) {
+	// Попутно чистим истекшие ключи
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < NOW()"); err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, lock_id, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (user_id, idempotency_key) DO NOTHING`,
+		userID, key, lock.RequestHash, lock.LockID, time.Now().Add(lockTTL).UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 1 {
+		return nil, err
+	}
+
+	var record Record
+	var status sql.NullInt64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT request_hash, status_code, content_type, response_body
+		 FROM idempotency_keys
+		 WHERE user_id = $1 AND idempotency_key = $2`,
+		userID, key,
+	).Scan(&record.RequestHash, &status, &record.ContentType, &record.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Первый запрос успел освободить ключ, повторим при следующей попытке
+		return &Record{RequestHash: lock.RequestHash}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record.Status = int(status.Int64)
+	return &record, nil
+}
+
+func (s *postgresStore) Complete(ctx context.Context, userID int, key string, lock, record *Record, ttl time.Duration) (bool, error) {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys
+		 SET status_code = $1, content_type = $2, response_body = $3, expires_at = $4
+		 WHERE user_id = $5 AND idempotency_key = $6 AND lock_id = $7 AND status_code IS NULL`,
+		record.Status, record.ContentType, record.Body, time.Now().Add(ttl).UTC(), userID, key, lock.LockID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	return updated == 1, err
+}
+
+func (s *postgresStore) Release(ctx context.Context, userID int, key string, lock *Record) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys
+		 WHERE user_id = $1 AND idempotency_key = $2 AND lock_id = $3 AND status_code IS NULL`,
+		userID, key, lock.LockID,
+	)
+	return err
+}
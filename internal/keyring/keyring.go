@@ -0,0 +1,263 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config настройки набора ключей подписи JWT
+type Config struct {
+	// Secret общий секрет HS256. Используется для подписи, только если не
+	// заданы асимметричные ключи, иначе остается ключом проверки старых токенов
+	Secret string
+	// SecretExpiresAt момент в RFC 3339, после которого токены без kid,
+	// подписанные Secret, не принимаются. Пустая строка — без срока
+	SecretExpiresAt string
+	// Keys записи вида "kid=/path/key.pem" или "kid=/path/key.pem@2025-01-31T00:00:00Z",
+	// где после @ указан момент, до которого ключ принимается для проверки
+	Keys []string
+	// ActiveKeyID kid ключа, которым подписываются новые токены
+	ActiveKeyID string
+}
+
+// Key ключ подписи или проверки, идентифицируемый kid
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	ExpiresAt time.Time // нулевое значение — без срока
+
+	signingKey   interface{}
+	verifyingKey interface{}
+}
+
+// Algorithm возвращает имя алгоритма JWS (RS256, ES256, EdDSA, HS256)
+func (k *Key) Algorithm() string {
+	return k.Method.Alg()
+}
+
+func (k *Key) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// Keyring набор ключей: один активный ключ подписи и ключи, сохраненные для
+// проверки ранее выданных токенов
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+	// legacy ключ для токенов без kid, выданных до включения ротации
+	legacy *Key
+}
+
+const legacyKeyID = "hs256"
+
+// Load загружает ключи из файлов, перечисленных в конфигурации
+func Load(cfg Config) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*Key)}
+
+	var secretKey *Key
+	if cfg.Secret != "" {
+		secretKey = &Key{
+			ID:           legacyKeyID,
+			Method:       jwt.SigningMethodHS256,
+			signingKey:   []byte(cfg.Secret),
+			verifyingKey: []byte(cfg.Secret),
+		}
+		if cfg.SecretExpiresAt != "" {
+			expiresAt, err := time.Parse(time.RFC3339, cfg.SecretExpiresAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiry for the JWT secret: %w", err)
+			}
+			secretKey.ExpiresAt = expiresAt
+		}
+	}
+
+	for _, entry := range cfg.Keys {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, err := loadKeyEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := k.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	if len(k.keys) == 0 {
+		// Асимметричных ключей нет — подписываем общим секретом, как раньше
+		if secretKey == nil {
+			return nil, errors.New("either JWT secret or signing keys are required")
+		}
+		if secretKey.expired(time.Now()) {
+			return nil, errors.New("JWT secret has expired and no signing keys are configured")
+		}
+		k.keys[secretKey.ID] = secretKey
+		k.active = secretKey
+		k.legacy = secretKey
+		return k, nil
+	}
+
+	active, ok := k.keys[cfg.ActiveKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not configured", cfg.ActiveKeyID)
+	}
+	if active.signingKey == nil {
+		return nil, fmt.Errorf("active key %q has no private key", cfg.ActiveKeyID)
+	}
+	if active.expired(time.Now()) {
+		return nil, fmt.Errorf("active key %q has expired", cfg.ActiveKeyID)
+	}
+	k.active = active
+	k.legacy = secretKey
+
+	return k, nil
+}
+
+func loadKeyEntry(entry string) (*Key, error) {
+	kid, path, ok := strings.Cut(entry, "=")
+	if !ok || kid == "" || path == "" {
+		return nil, fmt.Errorf("invalid key entry %q, expected kid=path", entry)
+	}
+
+	key := &Key{ID: kid}
+	if p, expires, ok := strings.Cut(path, "@"); ok {
+		expiresAt, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry for key %q: %w", kid, err)
+		}
+		path = p
+		key.ExpiresAt = expiresAt
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %q: %w", kid, err)
+	}
+
+	if err := key.parsePEM(data); err != nil {
+		return nil, fmt.Errorf("failed to parse key %q: %w", kid, err)
+	}
+
+	return key, nil
+}
+
+// parsePEM разбирает закрытый (PKCS#8, PKCS#1, SEC1) или открытый (PKIX) ключ
+func (k *Key) parsePEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		k.signingKey = signer
+		parsed = signer.Public()
+	}
+
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		k.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			k.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			k.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			k.Method = jwt.SigningMethodES512
+		default:
+			return errors.New("unsupported elliptic curve")
+		}
+	case ed25519.PublicKey:
+		k.Method = jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("unsupported key type %T", parsed)
+	}
+	k.verifyingKey = parsed
+
+	return nil
+}
+
+// Active возвращает ключ, которым подписываются новые токены
+func (k *Keyring) Active() *Key {
+	return k.active
+}
+
+// Sign подписывает claims активным ключом и проставляет kid в заголовок
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	if k.active != k.legacy {
+		token.Header["kid"] = k.active.ID
+	}
+	return token.SignedString(k.active.signingKey)
+}
+
+// Parse проверяет подпись токена ключом из заголовка kid. Алгоритм токена
+// обязан совпадать с алгоритмом ключа, чтобы исключить подмену alg
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		key, err := k.lookup(token)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Algorithm() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verifyingKey, nil
+	}, opts...)
+}
+
+func (k *Keyring) lookup(token *jwt.Token) (*Key, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if k.legacy == nil {
+			return nil, errors.New("token has no kid")
+		}
+		if k.legacy.expired(time.Now()) {
+			return nil, errors.New("tokens without kid are no longer accepted")
+		}
+		return k.legacy, nil
+	}
+
+	key, ok := k.keys[kid]
+	if !ok || key == k.legacy {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if key.expired(time.Now()) {
+		return nil, fmt.Errorf("key %q has expired", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,25 @@
+package money
+
+// exponents число знаков после запятой в валютах ISO 4217, которые принимает
+// сервис
+var exponents = map[string]int{
+	"AED": 2, "AUD": 2, "BGN": 2, "BHD": 3, "BRL": 2, "BYN": 2, "CAD": 2,
+	"CHF": 2, "CLP": 0, "CNY": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2,
+	"GEL": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "ISK": 0,
+	"JOD": 3, "JPY": 0, "KGS": 2, "KRW": 0, "KWD": 3, "KZT": 2, "MXN": 2,
+	"NOK": 2, "NZD": 2, "OMR": 3, "PLN": 2, "RON": 2, "RSD": 2, "RUB": 2,
+	"SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2, "UAH": 2,
+	"USD": 2, "UZS": 2, "VND": 0, "ZAR": 2,
+}
+
+// Exponent число знаков после запятой для валюты
+func Exponent(currency string) (int, bool) {
+	exp, ok := exponents[currency]
+	return exp, ok
+}
+
+// ValidCurrency сообщает, поддерживается ли валюта
+func ValidCurrency(currency string) bool {
+	_, ok := exponents[currency]
+	return ok
+}
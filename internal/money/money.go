@@ -0,0 +1,227 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"auth-user-service/internal/apierror"
+)
+
+// maxIntegerDigits ограничивает целую часть суммы размером столбцов
+// DECIMAL(15,3). Mul и Add отклоняют результат, который в них не помещается
+const maxIntegerDigits = 12
+
+var (
+	ErrUnknownCurrency  = apierror.Validation("unknown_currency", "Unknown or unsupported currency")
+	ErrInvalidAmount    = apierror.Validation("invalid_amount", "Amount must be a decimal number like 12.34")
+	ErrAmountTooLarge   = apierror.Validation("amount_too_large", "Amount is too large")
+	ErrInvalidMoney     = apierror.Validation("invalid_money", `Money must be an object like {"amount": "12.34", "currency": "USD"}`)
+	ErrCurrencyMismatch = apierror.Validation("currency_mismatch", "Amounts are in different currencies")
+)
+
+// Money сумма в минимальных единицах валюты (центах, копейках) и код валюты
+// ISO 4217. Нулевое значение без валюты означает, что сумма не указана
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse разбирает десятичную запись суммы. Знаков после запятой не может
+// быть больше, чем допускает валюта: 0.125 USD отклоняется, а не округляется
+func Parse(amount, currency string) (Money, error) {
+	return parse(amount, currency, true)
+}
+
+// FromDecimal разбирает сумму из столбца DECIMAL. Лишние знаки допустимы,
+// если это нули: столбец хранит больше знаков, чем нужно большинству валют
+func FromDecimal(amount, currency string) (Money, error) {
+	return parse(amount, currency, false)
+}
+
+func parse(amount, currency string, strict bool) (Money, error) {
+	exp, ok := Exponent(currency)
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+
+	digits, negative := strings.CutPrefix(amount, "-")
+	intPart, fracPart, hasPoint := strings.Cut(digits, ".")
+	if intPart == "" || (hasPoint && fracPart == "") || !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	intPart = strings.TrimLeft(intPart, "0")
+	if len(intPart) > maxIntegerDigits {
+		return Money{}, ErrAmountTooLarge
+	}
+
+	if len(fracPart) > exp {
+		if strict || strings.Trim(fracPart[exp:], "0") != "" {
+			return Money{}, apierror.Validation("amount_too_precise",
+				fmt.Sprintf("%s amounts allow at most %d decimal places", currency, exp))
+		}
+		fracPart = fracPart[:exp]
+	}
+	fracPart += strings.Repeat("0", exp-len(fracPart))
+
+	value, err := strconv.ParseInt("0"+intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, ErrAmountTooLarge
+	}
+	if negative {
+		value = -value
+	}
+
+	return Money{Amount: value, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsZero сообщает, что сумма не указана
+func (m Money) IsZero() bool {
+	return m == Money{}
+}
+
+// Mul умножает сумму на количество. Если результат не помещается в
+// maxIntegerDigits, возвращает ErrAmountTooLarge
+func (m Money) Mul(n int64) (Money, error) {
+	// Проверка до умножения: само произведение может переполнить int64
+	if n != 0 && abs(m.Amount) > (m.limit()-1)/abs(n) {
+		return Money{}, ErrAmountTooLarge
+	}
+	return m.checked(m.Amount * n)
+}
+
+// Add складывает суммы одной валюты. Если результат не помещается в
+// maxIntegerDigits, возвращает ErrAmountTooLarge
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	// Слагаемые меньше limit, поэтому их сумма не переполняет int64
+	if abs(m.Amount) >= m.limit() || abs(other.Amount) >= m.limit() {
+		return Money{}, ErrAmountTooLarge
+	}
+	return m.checked(m.Amount + other.Amount)
+}
+
+// limit наименьшая по модулю сумма в минимальных единицах, у которой
+// больше maxIntegerDigits знаков в целой части
+func (m Money) limit() int64 {
+	exp, ok := Exponent(m.Currency)
+	if !ok {
+		exp = 2
+	}
+	limit := int64(1)
+	for range maxIntegerDigits + exp {
+		limit *= 10
+	}
+	return limit
+}
+
+func (m Money) checked(amount int64) (Money, error) {
+	if abs(amount) >= m.limit() {
+		return Money{}, ErrAmountTooLarge
+	}
+	return Money{Amount: amount, Currency: m.Currency}, nil
+}
+
+// abs модуль n. Для math.MinInt64 возвращает math.MaxInt64, чтобы он не
+// оказался отрицательным
+func abs(n int64) int64 {
+	if n == math.MinInt64 {
+		return math.MaxInt64
+	}
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Decimal десятичная запись суммы, например 12.50. Подходит для записи в
+// столбец DECIMAL
+func (m Money) Decimal() string {
+	exp, ok := Exponent(m.Currency)
+	if !ok {
+		exp = 2
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+type jsonMoney struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON пишет сумму строкой, чтобы клиент не терял точность:
+// {"amount": "12.50", "currency": "USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Decimal(), m.Currency})
+}
+
+// UnmarshalJSON принимает сумму строкой или числом. Число разбирается как
+// текст, без промежуточного float64
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var value jsonMoney
+	if err := json.Unmarshal(data, &value); err != nil {
+		return ErrInvalidMoney
+	}
+	if value.Currency == "" {
+		return ErrUnknownCurrency
+	}
+
+	amount := string(value.Amount)
+	if bytes.HasPrefix(value.Amount, []byte(`"`)) {
+		if err := json.Unmarshal(value.Amount, &amount); err != nil {
+			return ErrInvalidAmount
+		}
+	}
+
+	parsed, err := Parse(amount, value.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,244 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{"usd cents", "12.34", "USD", 1234, false},
+		{"usd one decimal", "12.5", "USD", 1250, false},
+		{"usd whole", "12", "USD", 1200, false},
+		{"usd too precise", "0.125", "USD", 0, true},
+		{"jpy whole", "500", "JPY", 500, false},
+		{"jpy no decimals", "500.0", "JPY", 0, true},
+		{"kwd three decimals", "1.234", "KWD", 1234, false},
+		{"kwd too precise", "1.2345", "KWD", 0, true},
+		{"negative", "-12.34", "USD", -1234, false},
+		{"negative zero", "-0", "USD", 0, false},
+		{"leading zeros", "000012.34", "USD", 1234, false},
+		{"zero", "0.00", "USD", 0, false},
+		{"max integer digits", "999999999999.99", "USD", 99999999999999, false},
+		{"too many integer digits", "1000000000000", "USD", 0, true},
+		{"leading zeros do not count", "0000999999999999", "JPY", 999999999999, false},
+		{"empty", "", "USD", 0, true},
+		{"no integer part", ".5", "USD", 0, true},
+		{"trailing point", "1.", "USD", 0, true},
+		{"plus sign", "+1", "USD", 0, true},
+		{"double minus", "--1", "USD", 0, true},
+		{"letters", "1e3", "USD", 0, true},
+		{"spaces", " 1", "USD", 0, true},
+		{"unknown currency", "1", "XXX", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.amount, tt.currency)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q, %q) = %v, want error", tt.amount, tt.currency, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q, %q) error: %v", tt.amount, tt.currency, err)
+			}
+			if got.Amount != tt.want || got.Currency != tt.currency {
+				t.Errorf("Parse(%q, %q) = %d %s, want %d %s", tt.amount, tt.currency, got.Amount, got.Currency, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestFromDecimal(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{"extra zeros", "12.340", "USD", 1234, false},
+		{"extra digit", "12.345", "USD", 0, true},
+		{"jpy column scale", "500.000", "JPY", 500, false},
+		{"kwd column scale", "1.234", "KWD", 1234, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromDecimal(tt.amount, tt.currency)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("FromDecimal(%q, %q) = %v, want error", tt.amount, tt.currency, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromDecimal(%q, %q) error: %v", tt.amount, tt.currency, err)
+			}
+			if got.Amount != tt.want {
+				t.Errorf("FromDecimal(%q, %q) = %d, want %d", tt.amount, tt.currency, got.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(1234, "USD"), "12.34"},
+		{New(5, "USD"), "0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(-5, "USD"), "-0.05"},
+		{New(-1234, "USD"), "-12.34"},
+		{New(500, "JPY"), "500"},
+		{New(-500, "JPY"), "-500"},
+		{New(1, "KWD"), "0.001"},
+		{New(1234, "KWD"), "1.234"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("%d %s Decimal() = %q, want %q", tt.money.Amount, tt.money.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		name    string
+		money   Money
+		n       int64
+		want    int64
+		wantErr error
+	}{
+		{"simple", New(1250, "USD"), 3, 3750, nil},
+		{"zero quantity", New(1250, "USD"), 0, 0, nil},
+		{"negative amount", New(-1250, "USD"), 2, -2500, nil},
+		{"largest result", New(99999999999999, "USD"), 1, 99999999999999, nil},
+		{"just over the limit", New(50000000000000, "USD"), 2, 0, ErrAmountTooLarge},
+		{"int64 overflow", New(18446744073710, "USD"), 1000000, 0, ErrAmountTooLarge},
+		{"huge quantity", New(1, "USD"), 1 << 62, 0, ErrAmountTooLarge},
+		{"negative overflow", New(-50000000000000, "USD"), 2, 0, ErrAmountTooLarge},
+		{"jpy limit", New(999999999999, "JPY"), 1, 999999999999, nil},
+		{"jpy over limit", New(500000000000, "JPY"), 2, 0, ErrAmountTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.money.Mul(tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Mul(%d) error = %v, want %v", tt.n, err, tt.wantErr)
+			}
+			if err == nil && (got.Amount != tt.want || got.Currency != tt.money.Currency) {
+				t.Errorf("Mul(%d) = %d %s, want %d %s", tt.n, got.Amount, got.Currency, tt.want, tt.money.Currency)
+			}
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		want    int64
+		wantErr error
+	}{
+		{"simple", New(150, "USD"), New(250, "USD"), 400, nil},
+		{"negative", New(150, "USD"), New(-250, "USD"), -100, nil},
+		{"up to the limit", New(99999999999998, "USD"), New(1, "USD"), 99999999999999, nil},
+		{"over the limit", New(99999999999999, "USD"), New(1, "USD"), 0, ErrAmountTooLarge},
+		{"operand over the limit", New(1<<62, "USD"), New(1<<62, "USD"), 0, ErrAmountTooLarge},
+		{"currency mismatch", New(1, "USD"), New(1, "EUR"), 0, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Add(tt.b)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Add error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.Amount != tt.want {
+				t.Errorf("Add = %d, want %d", got.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{"string amount", `{"amount": "12.34", "currency": "USD"}`, New(1234, "USD"), false},
+		{"number amount", `{"amount": 12.34, "currency": "USD"}`, New(1234, "USD"), false},
+		{"integer number", `{"amount": 12, "currency": "USD"}`, New(1200, "USD"), false},
+		{"negative number", `{"amount": -0.5, "currency": "USD"}`, New(-50, "USD"), false},
+		{"number beyond float64 precision", `{"amount": 999999999999.99, "currency": "USD"}`, New(99999999999999, "USD"), false},
+		{"exponent number", `{"amount": 1e2, "currency": "USD"}`, Money{}, true},
+		{"too precise string", `{"amount": "0.125", "currency": "USD"}`, Money{}, true},
+		{"too precise number", `{"amount": 0.125, "currency": "USD"}`, Money{}, true},
+		{"missing currency", `{"amount": "1"}`, Money{}, true},
+		{"unknown currency", `{"amount": "1", "currency": "XXX"}`, Money{}, true},
+		{"bare number", `12.34`, Money{}, true},
+		{"boolean amount", `{"amount": true, "currency": "USD"}`, Money{}, true},
+		{"null", `null`, Money{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal(%s) = %v, want error", tt.json, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) error: %v", tt.json, err)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.json, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(1250, "USD"), `{"amount":"12.50","currency":"USD"}`},
+		{New(-5, "USD"), `{"amount":"-0.05","currency":"USD"}`},
+		{New(500, "JPY"), `{"amount":"500","currency":"JPY"}`},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.money)
+		if err != nil {
+			t.Fatalf("Marshal(%v) error: %v", tt.money, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Marshal(%v) = %s, want %s", tt.money, data, tt.want)
+		}
+
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil || back != tt.money {
+			t.Errorf("round trip of %v = %v, %v", tt.money, back, err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/auth"
+)
+
+type Handler struct {
+	service     Service
+	authService auth.Service
+}
+
+func NewHandler(service Service, authService auth.Service) *Handler {
+	return &Handler{service: service, authService: authService}
+}
+
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Error}}<p style="color:#b00">{{.Error}}</p>{{end}}
+<form method="post" action="/authorize">
+  {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+  {{end}}{{if .MFAToken}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+  <label>Authentication code <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label><br>
+  <label>or recovery code <input type="text" name="recovery_code"></label><br>
+  <button type="submit">Verify</button>
+  {{else}}<label>Email <input type="email" name="email" value="{{.Email}}" required></label><br>
+  <label>Password <input type="password" name="password" required></label><br>
+  <button type="submit">Sign in</button>
+  {{end}}
+</form>
+</body>
+</html>
+`))
+
+func (h *Handler) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeJSON(w, h.service.Discovery(), http.StatusOK)
+}
+
+// Authorize обрабатывает GET (показ формы входа) и POST (отправка формы).
+// Пользователь, уже предъявивший Bearer токен, получает код сразу
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req := &AuthorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		Prompt:              r.Form.Get("prompt"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+
+	client, err := h.service.ValidateAuthorizeRequest(req)
+	if err != nil {
+		h.writeAuthorizeError(w, r, req, err)
+		return
+	}
+
+	// Уже аутентифицированный пользователь. Токен, выданный другому
+	// клиенту, входом не считается
+	if bearer := bearerToken(r); bearer != "" {
+		claims, err := h.authService.ValidateToken(bearer)
+		if err == nil && claims.ClientID == "" {
+			h.issueCode(w, r, req, claims.UserID, claims.IssuedAt)
+			return
+		}
+	}
+
+	if req.Prompt == "none" {
+		h.writeAuthorizeError(w, r, req, &Error{Code: "login_required", Status: 400, Redirect: true})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.renderLogin(w, client, req, "", "", "", http.StatusOK)
+		return
+	}
+
+	// Второй шаг входа при включенной двухфакторной аутентификации
+	if mfaToken := r.PostForm.Get("mfa_token"); mfaToken != "" {
+		user, err := h.authService.VerifyMFA(mfaToken, r.PostForm.Get("code"), r.PostForm.Get("recovery_code"))
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidMFAToken) {
+				h.renderLogin(w, client, req, "", "", "Login session expired, please sign in again", http.StatusUnauthorized)
+				return
+			}
+			if errors.Is(err, auth.ErrLoginLocked) {
+				h.renderLogin(w, client, req, "", mfaToken, "Too many failed attempts, please try again later", http.StatusTooManyRequests)
+				return
+			}
+			if !errors.Is(err, auth.ErrInvalidMFACode) {
+				log.Printf("Error verifying MFA: %v", err)
+			}
+			h.renderLogin(w, client, req, "", mfaToken, "Invalid authentication code", http.StatusUnauthorized)
+			return
+		}
+		h.issueCode(w, r, req, user.ID, time.Now())
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	user, err := h.authService.Login(email, r.PostForm.Get("password"), auth.ClientIP(r))
+	if err != nil {
+		if errors.Is(err, auth.ErrLoginLocked) {
+			h.renderLogin(w, client, req, email, "", "Too many failed attempts, please try again later", http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, auth.ErrEmailNotVerified) {
+			h.renderLogin(w, client, req, email, "", "Please confirm your email address first", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			h.renderLogin(w, client, req, email, "", "This account has been disabled", http.StatusForbidden)
+			return
+		}
+		h.renderLogin(w, client, req, email, "", "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	mfaToken, err := h.authService.MFAChallenge(user)
+	if err != nil {
+		log.Printf("Error starting MFA challenge: %v", err)
+		h.writeAuthorizeError(w, r, req, &Error{Code: "server_error", Status: 500, Redirect: true})
+		return
+	}
+	if mfaToken != "" {
+		h.renderLogin(w, client, req, "", mfaToken, "", http.StatusOK)
+		return
+	}
+
+	h.issueCode(w, r, req, user.ID, time.Now())
+}
+
+func (h *Handler) issueCode(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, userID int, authTime time.Time) {
+	redirectURL, err := h.service.IssueCode(req, userID, authTime)
+	if err != nil {
+		log.Printf("Error issuing authorization code: %v", err)
+		h.writeAuthorizeError(w, r, req, &Error{Code: "server_error", Status: 500, Redirect: true})
+		return
+	}
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.writeOAuthError(w, &Error{Code: "invalid_request", Description: "invalid form body", Status: 400})
+		return
+	}
+
+	req := &TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Device:       auth.NewClientInfo(r),
+	}
+
+	// client_secret_basic: идентификатор и секрет закодированы по RFC 6749 2.3.1
+	if id, secret, ok := r.BasicAuth(); ok {
+		req.ClientID, _ = url.QueryUnescape(id)
+		req.ClientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	response, err := h.service.Exchange(req)
+	if err != nil {
+		var oauthErr *Error
+		if errors.As(err, &oauthErr) {
+			h.writeOAuthError(w, oauthErr)
+			return
+		}
+		log.Printf("Error exchanging token: %v", err)
+		h.writeOAuthError(w, &Error{Code: "server_error", Status: 500})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	h.writeJSON(w, response, http.StatusOK)
+}
+
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	if !ok {
+		h.writeOAuthError(w, errInvalidToken)
+		return
+	}
+
+	// Scopes токена самого сервиса — права пользователя, а не scope OIDC
+	var scope string
+	if claims.ClientID != "" {
+		scope = strings.Join(claims.Scopes, " ")
+	}
+
+	info, err := h.service.UserInfo(claims.UserID, scope)
+	if err != nil {
+		var oauthErr *Error
+		if errors.As(err, &oauthErr) {
+			h.writeOAuthError(w, oauthErr)
+			return
+		}
+		log.Printf("Error getting userinfo: %v", err)
+		h.writeOAuthError(w, &Error{Code: "server_error", Status: 500})
+		return
+	}
+
+	h.writeJSON(w, info, http.StatusOK)
+}
+
+// Вспомогательные методы
+func (h *Handler) renderLogin(w http.ResponseWriter, client *Client, req *AuthorizeRequest, email, mfaToken, message string, statusCode int) {
+	params := map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(statusCode)
+	err := loginTemplate.Execute(w, map[string]interface{}{
+		"ClientName": client.Name,
+		"Params":     params,
+		"Email":      email,
+		"MFAToken":   mfaToken,
+		"Error":      message,
+	})
+	if err != nil {
+		log.Printf("Error rendering login page: %v", err)
+	}
+}
+
+func (h *Handler) writeAuthorizeError(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, err error) {
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		log.Printf("Error validating authorize request: %v", err)
+		oauthErr = &Error{Code: "server_error", Status: 500}
+	}
+
+	if !oauthErr.Redirect {
+		h.writeOAuthError(w, oauthErr)
+		return
+	}
+
+	redirectURL := RedirectURL(req.RedirectURI, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+		"state":             {req.State},
+	})
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (h *Handler) writeOAuthError(w http.ResponseWriter, err *Error) {
+	if err.Code == errInvalidClient.Code && err.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	} else if err.Status == http.StatusUnauthorized || err.Status == http.StatusForbidden {
+		w.Header().Set("WWW-Authenticate", `Bearer error="`+err.Code+`"`)
+	}
+	h.writeJSON(w, ErrorResponse{Error: err.Code, ErrorDescription: err.Description}, err.Status)
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return header[7:]
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package oidc
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Repository interface {
+	GetClient(clientID string) (*Client, error)
+	SaveAuthorizationCode(code *AuthorizationCode) error
+	ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error)
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return &repository{db: db}
+}
+
+// Client зарегистрированный OAuth клиент
+type Client struct {
+	ClientID         string
+	ClientSecretHash string // пустой у публичных клиентов
+	Name             string
+	RedirectURIs     []string
+}
+
+// IsPublic сообщает, что клиент не может хранить секрет (SPA, мобильное приложение)
+func (c *Client) IsPublic() bool {
+	return c.ClientSecretHash == ""
+}
+
+// AllowsRedirect проверяет точное совпадение redirect_uri с зарегистрированным
+func (c *Client) AllowsRedirect(redirectURI string) bool {
+	for _, uri := range c.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationCode одноразовый код авторизации
+type AuthorizationCode struct {
+	CodeHash            string
+	ClientID            string
+	UserID              int
+	RedirectURI         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+}
+
+func (r *repository) GetClient(clientID string) (*Client, error) {
+	var client Client
+	var secretHash sql.NullString
+	err := r.db.QueryRow(
+		`SELECT client_id, client_secret_hash, name, redirect_uris
+		 FROM oauth_clients
+		 WHERE client_id = $1`,
+		clientID,
+	).Scan(&client.ClientID, &secretHash, &client.Name, pq.Array(&client.RedirectURIs))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.ClientSecretHash = secretHash.String
+	return &client, nil
+}
+
+func (r *repository) SaveAuthorizationCode(code *AuthorizationCode) error {
+	_, err := r.db.Exec(
+		`INSERT INTO oauth_authorization_codes
+		 (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.CodeChallengeMethod, code.AuthTime.UTC(), code.ExpiresAt.UTC(),
+	)
+	return err
+}
+
+// ConsumeAuthorizationCode атомарно помечает код использованным и возвращает
+// его. Повторно использованный или истекший код не возвращается
+func (r *repository) ConsumeAuthorizationCode(codeHash string) (*AuthorizationCode, error) {
+	var code AuthorizationCode
+	err := r.db.QueryRow(
+		`UPDATE oauth_authorization_codes
+		 SET used_at = NOW()
+		 WHERE code_hash = $1 AND used_at IS NULL AND expires_at > $2
+		 RETURNING code_hash, client_id, user_id, redirect_uri, scope, COALESCE(nonce, ''),
+		 code_challenge, code_challenge_method, auth_time, expires_at`,
+		codeHash, time.Now().UTC(),
+	).Scan(
+		&code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, &code.Scope, &code.Nonce,
+		&code.CodeChallenge, &code.CodeChallengeMethod, &code.AuthTime, &code.ExpiresAt,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,477 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/auth"
+	"auth-user-service/internal/keyring"
+	"auth-user-service/internal/user"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Service interface {
+	Discovery() *DiscoveryDocument
+	ValidateAuthorizeRequest(req *AuthorizeRequest) (*Client, error)
+	IssueCode(req *AuthorizeRequest, userID int, authTime time.Time) (string, error)
+	Exchange(req *TokenRequest) (*TokenResponse, error)
+	UserInfo(userID int, scope string) (*UserInfo, error)
+}
+
+// Config настройки OIDC провайдера
+type Config struct {
+	Issuer      string
+	AuthCodeTTL time.Duration
+}
+
+var supportedScopes = []string{"openid", "profile", "email", "phone", "address", "offline_access"}
+
+// Error ошибка в формате RFC 6749. Redirect означает, что ошибку можно
+// вернуть клиенту через redirect_uri, иначе она показывается пользователю
+type Error struct {
+	Code        string
+	Description string
+	Status      int
+	Redirect    bool
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func invalidRequest(description string) *Error {
+	return &Error{Code: "invalid_request", Description: description, Status: 400, Redirect: true}
+}
+
+func invalidGrant(description string) *Error {
+	return &Error{Code: "invalid_grant", Description: description, Status: 400}
+}
+
+var errInvalidClient = &Error{Code: "invalid_client", Description: "client authentication failed", Status: 401}
+
+var (
+	errInvalidToken      = &Error{Code: "invalid_token", Description: "access token is invalid", Status: 401}
+	errInsufficientScope = &Error{Code: "insufficient_scope", Description: "openid scope is required", Status: 403}
+)
+
+// AuthorizeRequest параметры /authorize
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	Prompt              string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// TokenRequest параметры /token
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+	// Device вызывающая сторона; обычно это сервер приложения, а не браузер
+	Device auth.ClientInfo
+}
+
+// TokenResponse ответ /token
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// UserInfo стандартные claims OpenID Connect. Заполняются только claims
+// выданных токену scope
+type UserInfo struct {
+	Subject    string `json:"sub"`
+	Email      string `json:"email,omitempty"`
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	Phone      string `json:"phone_number,omitempty"`
+	Address    *struct {
+		Formatted string `json:"formatted"`
+	} `json:"address,omitempty"`
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+}
+
+// DiscoveryDocument /.well-known/openid-configuration
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type service struct {
+	repo        Repository
+	authService auth.Service
+	userRepo    user.Repository
+	keys        *keyring.Keyring
+	issuer      string
+	authCodeTTL time.Duration
+}
+
+func NewService(repo Repository, authService auth.Service, userRepo user.Repository, keys *keyring.Keyring, cfg Config) Service {
+	if cfg.AuthCodeTTL <= 0 {
+		cfg.AuthCodeTTL = time.Minute
+	}
+	return &service{
+		repo:        repo,
+		authService: authService,
+		userRepo:    userRepo,
+		keys:        keys,
+		issuer:      strings.TrimSuffix(cfg.Issuer, "/"),
+		authCodeTTL: cfg.AuthCodeTTL,
+	}
+}
+
+func (s *service) Discovery() *DiscoveryDocument {
+	return &DiscoveryDocument{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             s.issuer + "/authorize",
+		TokenEndpoint:                     s.issuer + "/token",
+		UserInfoEndpoint:                  s.issuer + "/userinfo",
+		JWKSURI:                           s.issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.keys.Active().Algorithm()},
+		ScopesSupported:                   supportedScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "name", "given_name", "family_name", "phone_number", "address",
+		},
+	}
+}
+
+// ValidateAuthorizeRequest проверяет клиента и параметры запроса. Пока
+// redirect_uri не подтвержден, ошибки не перенаправляются клиенту
+func (s *service) ValidateAuthorizeRequest(req *AuthorizeRequest) (*Client, error) {
+	client, err := s.repo.GetClient(req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	if client == nil {
+		return nil, &Error{Code: "invalid_client", Description: "unknown client_id", Status: 400}
+	}
+	if !client.AllowsRedirect(req.RedirectURI) {
+		return nil, &Error{Code: "invalid_request", Description: "redirect_uri is not registered for this client", Status: 400}
+	}
+
+	if req.ResponseType != "code" {
+		return nil, &Error{Code: "unsupported_response_type", Description: "only response_type=code is supported", Status: 400, Redirect: true}
+	}
+	if !hasScope(req.Scope, "openid") {
+		return nil, &Error{Code: "invalid_scope", Description: "scope must include openid", Status: 400, Redirect: true}
+	}
+	for _, scope := range strings.Fields(req.Scope) {
+		if !hasScope(strings.Join(supportedScopes, " "), scope) {
+			return nil, &Error{Code: "invalid_scope", Description: "unsupported scope " + scope, Status: 400, Redirect: true}
+		}
+	}
+
+	// PKCE обязателен для всех клиентов, допускается только S256
+	if req.CodeChallenge == "" {
+		return nil, invalidRequest("code_challenge is required")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return nil, invalidRequest("code_challenge_method must be S256")
+	}
+
+	return client, nil
+}
+
+// IssueCode выдает код авторизации и возвращает адрес перенаправления клиента
+func (s *service) IssueCode(req *AuthorizeRequest, userID int, authTime time.Time) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+
+	err = s.repo.SaveAuthorizationCode(&AuthorizationCode{
+		CodeHash:            hashString(code),
+		ClientID:            req.ClientID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            authTime,
+		ExpiresAt:           time.Now().Add(s.authCodeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save authorization code: %w", err)
+	}
+
+	return RedirectURL(req.RedirectURI, url.Values{"code": {code}, "state": {req.State}}), nil
+}
+
+func (s *service) Exchange(req *TokenRequest) (*TokenResponse, error) {
+	client, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case "authorization_code":
+		return s.exchangeCode(client, req)
+	case "refresh_token":
+		return s.exchangeRefreshToken(client, req)
+	default:
+		return nil, &Error{Code: "unsupported_grant_type", Description: "unsupported grant_type", Status: 400}
+	}
+}
+
+func (s *service) authenticateClient(clientID, clientSecret string) (*Client, error) {
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := s.repo.GetClient(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	if client == nil {
+		return nil, errInvalidClient
+	}
+
+	if !client.IsPublic() {
+		if bcrypt.CompareHashAndPassword([]byte(client.ClientSecretHash), []byte(clientSecret)) != nil {
+			return nil, errInvalidClient
+		}
+	}
+
+	return client, nil
+}
+
+func (s *service) exchangeCode(client *Client, req *TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, &Error{Code: "invalid_request", Description: "code and code_verifier are required", Status: 400}
+	}
+
+	code, err := s.repo.ConsumeAuthorizationCode(hashString(req.Code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+	if code == nil || code.ClientID != client.ClientID {
+		return nil, invalidGrant("invalid or expired authorization code")
+	}
+	if code.RedirectURI != req.RedirectURI {
+		return nil, invalidGrant("redirect_uri does not match")
+	}
+	if !verifyPKCE(code.CodeChallenge, req.CodeVerifier) {
+		return nil, invalidGrant("code_verifier does not match code_challenge")
+	}
+
+	u, err := s.authService.GetUserByID(code.UserID)
+	if err != nil {
+		return nil, invalidGrant("user not found")
+	}
+
+	// Клиент получает токены только с выданными ему scope
+	grant := auth.ClientGrant{ClientID: client.ClientID, Scope: code.Scope}
+	tokens, err := s.authService.IssueClientTokens(u, grant, req.Device)
+	if errors.Is(err, auth.ErrEmailNotVerified) {
+		return nil, invalidGrant("email address is not verified")
+	}
+	if errors.Is(err, auth.ErrAccountDisabled) {
+		return nil, invalidGrant("account is disabled")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := s.signIDToken(u, client.ClientID, code.Scope, code.Nonce, code.AuthTime, tokens.ExpiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      idToken,
+		Scope:        code.Scope,
+	}, nil
+}
+
+func (s *service) exchangeRefreshToken(client *Client, req *TokenRequest) (*TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, &Error{Code: "invalid_request", Description: "refresh_token is required", Status: 400}
+	}
+
+	u, tokens, err := s.authService.RefreshToken(req.RefreshToken, client.ClientID, req.Device)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			return nil, invalidGrant("invalid refresh token")
+		}
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			return nil, invalidGrant("account is disabled")
+		}
+		return nil, err
+	}
+
+	idToken, err := s.signIDToken(u, client.ClientID, "openid", "", time.Time{}, tokens.ExpiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      idToken,
+	}, nil
+}
+
+func (s *service) signIDToken(u *auth.User, clientID, scope, nonce string, authTime time.Time, expiresIn int) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.issuer,
+		"sub": strconv.Itoa(u.ID),
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Duration(expiresIn) * time.Second).Unix(),
+	}
+	if !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if hasScope(scope, "email") {
+		claims["email"] = u.Email
+	}
+	if hasScope(scope, "profile") {
+		claims["given_name"] = u.FirstName
+		claims["family_name"] = u.LastName
+	}
+
+	idToken, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign id token: %w", err)
+	}
+	return idToken, nil
+}
+
+func (s *service) UserInfo(userID int, scope string) (*UserInfo, error) {
+	if !hasScope(scope, "openid") {
+		return nil, errInsufficientScope
+	}
+
+	profile, err := s.userRepo.GetProfile(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	if profile == nil {
+		return nil, errInvalidToken
+	}
+
+	info := &UserInfo{Subject: strconv.Itoa(profile.ID)}
+	if hasScope(scope, "email") {
+		info.Email = profile.Email
+	}
+	if hasScope(scope, "profile") {
+		info.Name = strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+		info.GivenName = profile.FirstName
+		info.FamilyName = profile.LastName
+		info.UpdatedAt = profile.UpdatedAt.Unix()
+	}
+	if hasScope(scope, "phone") {
+		info.Phone = profile.Phone
+	}
+	if hasScope(scope, "address") && profile.Address != "" {
+		info.Address = &struct {
+			Formatted string `json:"formatted"`
+		}{Formatted: profile.Address}
+	}
+
+	return info, nil
+}
+
+// RedirectURL добавляет параметры к redirect_uri клиента
+func RedirectURL(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// verifyPKCE проверяет code_verifier по RFC 7636 (метод S256)
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashString(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
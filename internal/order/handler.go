@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strconv"
 
+	"auth-user-service/internal/apierror"
+
 	"github.com/go-chi/chi/v5"
 )
 
@@ -16,32 +18,28 @@ func NewHandler(service Service) *Handler {
 	return &Handler{service: service}
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
 func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
-		h.writeError(w, "User not authenticated", http.StatusUnauthorized)
+		h.writeError(w, apierror.ErrUnauthenticated)
 		return
 	}
 
 	orderIDStr := chi.URLParam(r, "id")
 	orderID, err := strconv.Atoi(orderIDStr)
 	if err != nil {
-		h.writeError(w, "Invalid order ID", http.StatusBadRequest)
+		h.writeError(w, apierror.ErrInvalidID)
 		return
 	}
 
 	order, err := h.service.GetOrder(orderID, userID)
 	if err != nil {
-		h.writeError(w, "Failed to get order", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
 	if order == nil {
-		h.writeError(w, "Order not found", http.StatusNotFound)
+		h.writeError(w, ErrOrderNotFound)
 		return
 	}
 
@@ -51,50 +49,109 @@ func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
-		h.writeError(w, "User not authenticated", http.StatusUnauthorized)
+		h.writeError(w, apierror.ErrUnauthenticated)
 		return
 	}
 
 	var req CreateOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, "Invalid request", http.StatusBadRequest)
+		h.writeError(w, apierror.DecodeError(err))
 		return
 	}
 
-	// Простая валидация
-	if req.Title == "" {
-		h.writeError(w, "Title is required", http.StatusBadRequest)
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
 		return
 	}
 
-	if req.Price <= 0 {
-		h.writeError(w, "Price must be positive", http.StatusBadRequest)
+	order, err := h.service.CreateOrder(userID, req)
+	if err != nil {
+		h.writeError(w, err)
 		return
 	}
 
-	order, err := h.service.CreateOrder(userID, req.Title, req.Description, req.Price)
+	h.writeJSON(w, order, http.StatusCreated)
+}
+
+// GetUserOrders заказы пользователя постранично. Параметры фильтра и
+// сортировки описаны в ParseOrderFilter
+func (h *Handler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	filter, err := ParseOrderFilter(r.URL.Query())
 	if err != nil {
-		h.writeError(w, "Failed to create order", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
-	h.writeJSON(w, order, http.StatusCreated)
+	page, err := h.service.ListUserOrders(userID, filter)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	WritePageHeaders(w, r, page)
+	h.writeJSON(w, page.Orders, http.StatusOK)
 }
 
-func (h *Handler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+// CancelOrder отменяет заказ владельцем, пока он в статусе pending
+func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	var req CancelOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.DecodeError(err))
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	order, err := h.service.CancelOrder(orderID, userID, req.UpdatedAt)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, order, http.StatusOK)
+}
+
+func (h *Handler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
-		h.writeError(w, "User not authenticated", http.StatusUnauthorized)
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
 		return
 	}
 
-	orders, err := h.service.GetUserOrders(userID)
+	history, err := h.service.GetOrderHistory(orderID, userID)
 	if err != nil {
-		h.writeError(w, "Failed to get orders", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
-	h.writeJSON(w, orders, http.StatusOK)
+	h.writeJSON(w, history, http.StatusOK)
 }
 
 // Вспомогательные методы
@@ -106,6 +163,7 @@ func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode
 	}
 }
 
-func (h *Handler) writeError(w http.ResponseWriter, message string, statusCode int) {
-	h.writeJSON(w, ErrorResponse{Error: message}, statusCode)
+// writeError отвечает ошибкой в формате application/problem+json
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	apierror.Write(w, err)
 }
@@ -0,0 +1,225 @@
+package order
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/money"
+)
+
+// Варианты сортировки списка заказов. Минус означает убывание
+const (
+	SortCreatedAtDesc = "-created_at"
+	SortCreatedAtAsc  = "created_at"
+	SortPriceDesc     = "-price"
+	SortPriceAsc      = "price"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var ErrInvalidCursor = apierror.Validation("invalid_cursor", "Invalid cursor, start again from the first page")
+
+// OrderFilter параметры списка заказов. CreatedFrom включается в диапазон,
+// CreatedTo нет. After продолжает список с места, где закончилась
+// предыдущая страница
+type OrderFilter struct {
+	Statuses    []string
+	Currency    string
+	MinPrice    *money.Money
+	MaxPrice    *money.Money
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        string
+	Limit       int
+	After       *Cursor
+}
+
+// OrderPage страница списка. NextCursor пуст на последней странице
+type OrderPage struct {
+	Orders     []Order
+	Total      int
+	NextCursor string
+}
+
+// Cursor позиция в списке: значение столбца сортировки и id последнего
+// заказа на странице. Клиент получает его непрозрачной строкой
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// cursorAfter курсор, который продолжает список после заказа
+func cursorAfter(order Order, sort string) Cursor {
+	cursor := Cursor{Sort: sort, ID: order.ID}
+	switch sort {
+	case SortPriceAsc, SortPriceDesc:
+		cursor.Value = order.Price.Decimal()
+	default:
+		cursor.Value = order.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// ParseOrderFilter читает параметры списка из query строки:
+// ?status=pending,processing&currency=USD&min_price=&max_price=
+// &created_from=&created_to=&sort=-created_at&limit=20&cursor=
+func ParseOrderFilter(query url.Values) (OrderFilter, error) {
+	filter := OrderFilter{
+		Sort:     query.Get("sort"),
+		Currency: query.Get("currency"),
+		Limit:    defaultPageSize,
+	}
+	var fields apierror.Fields
+
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if !ValidStatus(status) {
+				fields.Add("status", "unknown_status", "is not a known order status")
+				break
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	if filter.Currency != "" && !money.ValidCurrency(filter.Currency) {
+		fields.Add("currency", "unknown_currency", "is not a supported currency")
+	}
+	for _, name := range []string{"min_price", "max_price"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		if filter.Currency == "" {
+			fields.Add(name, "currency_required", "needs the currency parameter")
+			continue
+		}
+		price, err := money.Parse(value, filter.Currency)
+		if err != nil {
+			fields.Add(name, "invalid_amount", "must be a decimal amount in "+filter.Currency)
+			continue
+		}
+		if name == "min_price" {
+			filter.MinPrice = &price
+		} else {
+			filter.MaxPrice = &price
+		}
+	}
+	if filter.MinPrice != nil && filter.MaxPrice != nil && filter.MinPrice.Amount > filter.MaxPrice.Amount {
+		fields.Add("max_price", "less_than_min", "must not be less than min_price")
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseDate(query.Get("created_from"), false); err != nil {
+		fields.Add("created_from", "invalid_date", "must be a date (2006-01-02) or an RFC 3339 time")
+	}
+	if filter.CreatedTo, err = parseDate(query.Get("created_to"), true); err != nil {
+		fields.Add("created_to", "invalid_date", "must be a date (2006-01-02) or an RFC 3339 time")
+	}
+
+	switch filter.Sort {
+	case "":
+		filter.Sort = SortCreatedAtDesc
+	case SortCreatedAtDesc, SortCreatedAtAsc:
+	case SortPriceDesc, SortPriceAsc:
+		// Суммы в разных валютах несравнимы
+		if filter.Currency == "" {
+			fields.Add("sort", "currency_required", "sorting by price needs the currency parameter")
+		}
+	default:
+		fields.Add("sort", "unknown_sort", "must be one of created_at, -created_at, price, -price")
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxPageSize {
+			fields.Add("limit", "out_of_range", fmt.Sprintf("must be between 1 and %d", maxPageSize))
+		}
+	}
+
+	if err := fields.Err(); err != nil {
+		return OrderFilter{}, err
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		if filter.After, err = decodeCursor(value); err != nil {
+			return OrderFilter{}, err
+		}
+		if filter.After.Sort != filter.Sort || !validCursorValue(filter.After) {
+			return OrderFilter{}, ErrInvalidCursor
+		}
+	}
+
+	return filter, nil
+}
+
+func validCursorValue(cursor *Cursor) bool {
+	switch cursor.Sort {
+	case SortPriceAsc, SortPriceDesc:
+		intPart, fracPart, _ := strings.Cut(cursor.Value, ".")
+		return intPart != "" && strings.Trim(intPart+fracPart, "0123456789") == ""
+	default:
+		_, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		return err == nil
+	}
+}
+
+// parseDate принимает дату или время RFC 3339. Дата в конце диапазона
+// включает весь день
+func parseDate(value string, endOfRange bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// WritePageHeaders сообщает клиенту общее число заказов в X-Total-Count и
+// ссылку на следующую страницу в Link
+func WritePageHeaders(w http.ResponseWriter, r *http.Request, page *OrderPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor == "" {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", page.NextCursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
@@ -0,0 +1,132 @@
+package order
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"auth-user-service/internal/money"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	orders := []struct {
+		order Order
+		sort  string
+	}{
+		{Order{ID: 7, CreatedAt: createdAt}, SortCreatedAtDesc},
+		{Order{ID: 8, CreatedAt: createdAt}, SortCreatedAtAsc},
+		{Order{ID: 9, Price: money.New(1250, "USD")}, SortPriceDesc},
+		{Order{ID: 10, Price: money.New(500, "JPY")}, SortPriceAsc},
+		{Order{ID: 11, Price: money.New(0, "USD")}, SortPriceAsc},
+	}
+
+	for _, tt := range orders {
+		cursor := cursorAfter(tt.order, tt.sort)
+		decoded, err := decodeCursor(cursor.Encode())
+		if err != nil {
+			t.Fatalf("decodeCursor(%+v) error: %v", cursor, err)
+		}
+		if *decoded != cursor {
+			t.Errorf("decodeCursor = %+v, want %+v", *decoded, cursor)
+		}
+		if !validCursorValue(decoded) {
+			t.Errorf("validCursorValue(%+v) = false, want true", *decoded)
+		}
+	}
+}
+
+func TestDecodeCursorTampering(t *testing.T) {
+	encode := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"price","v":"1.00","id":1}`))},
+		{"not json", encode("price:1.00:1")},
+		{"wrong id type", encode(`{"s":"price","v":"1.00","id":"1"}`)},
+		{"missing id", encode(`{"s":"price","v":"1.00"}`)},
+		{"zero id", encode(`{"s":"price","v":"1.00","id":0}`)},
+		{"negative id", encode(`{"s":"price","v":"1.00","id":-1}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
+
+func TestValidCursorValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+		want   bool
+	}{
+		{"price", Cursor{Sort: SortPriceAsc, Value: "12.50", ID: 1}, true},
+		{"whole price", Cursor{Sort: SortPriceDesc, Value: "500", ID: 1}, true},
+		{"empty price", Cursor{Sort: SortPriceAsc, Value: "", ID: 1}, false},
+		{"price without integer part", Cursor{Sort: SortPriceAsc, Value: ".5", ID: 1}, false},
+		{"negative price", Cursor{Sort: SortPriceAsc, Value: "-1.00", ID: 1}, false},
+		{"sql in price", Cursor{Sort: SortPriceAsc, Value: "1; DROP TABLE orders", ID: 1}, false},
+		{"two points", Cursor{Sort: SortPriceAsc, Value: "1.2.3", ID: 1}, false},
+		{"time", Cursor{Sort: SortCreatedAtDesc, Value: "2024-05-01T12:30:00.123456789Z", ID: 1}, true},
+		{"time with offset", Cursor{Sort: SortCreatedAtAsc, Value: "2024-05-01T12:30:00+03:00", ID: 1}, true},
+		{"date only", Cursor{Sort: SortCreatedAtDesc, Value: "2024-05-01", ID: 1}, false},
+		{"price in time cursor", Cursor{Sort: SortCreatedAtDesc, Value: "12.50", ID: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validCursorValue(&tt.cursor); got != tt.want {
+				t.Errorf("validCursorValue(%+v) = %v, want %v", tt.cursor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrderFilterCursor(t *testing.T) {
+	priceCursor := Cursor{Sort: SortPriceAsc, Value: "12.50", ID: 3}.Encode()
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"matching sort", "sort=price&currency=USD&cursor=" + priceCursor, false},
+		{"sort changed", "sort=-price&currency=USD&cursor=" + priceCursor, true},
+		{"default sort", "currency=USD&cursor=" + priceCursor, true},
+		{"tampered value", "sort=price&currency=USD&cursor=" + Cursor{Sort: SortPriceAsc, Value: "1 OR 1=1", ID: 3}.Encode(), true},
+		{"price sort without currency", "sort=price", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := ParseOrderFilter(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseOrderFilter(%q) = %+v, want error", tt.query, filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOrderFilter(%q) error: %v", tt.query, err)
+			}
+			if filter.After == nil || filter.After.ID != 3 {
+				t.Errorf("ParseOrderFilter(%q).After = %+v, want id 3", tt.query, filter.After)
+			}
+		})
+	}
+}
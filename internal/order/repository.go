@@ -2,13 +2,25 @@ package order
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/money"
+
+	"github.com/lib/pq"
 )
 
 type Repository interface {
 	GetOrder(orderID, userID int) (*Order, error)
 	CreateOrder(order *Order) (int, error)
-	GetUserOrders(userID int) ([]Order, error)
+	ListUserOrders(userID int, filter OrderFilter) ([]Order, int, error)
+	GetOrderItems(orderID int) ([]OrderItem, error)
+
+	GetOrderByID(orderID int) (*Order, error)
+	UpdateOrderStatus(orderID int, from, to string, updatedAt time.Time, changedBy int, reason string) (*Order, error)
+	GetOrderHistory(orderID int) ([]StatusChange, error)
 }
 
 type repository struct {
@@ -20,33 +32,218 @@ func NewRepository(db *sql.DB) Repository {
 }
 
 type Order struct {
-	ID          int       `json:"id"`
-	UserID      int       `json:"user_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int         `json:"id"`
+	UserID      int         `json:"user_id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	Status      string      `json:"status"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+
+	Items []OrderItem `json:"items,omitempty"`
+}
+
+// OrderItem позиция заказа. Discount скидка на всю позицию в деньгах,
+// Total стоимость позиции с учетом скидки. Валюта у всех сумм та же, что у
+// заказа
+type OrderItem struct {
+	ID        int         `json:"id"`
+	SKU       string      `json:"sku"`
+	Name      string      `json:"name"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+	Discount  money.Money `json:"discount"`
+	Total     money.Money `json:"total"`
 }
 
+// CreateOrderRequest новый заказ. Заказ из позиций передается в Items, и
+// его стоимость считает сервис. Без позиций заказ, как и раньше, состоит из
+// одной строки с ценой Price
 type CreateOrderRequest struct {
-	Title       string  `json:"title"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Price       money.Money        `json:"price"`
+	Items       []OrderItemRequest `json:"items"`
 }
 
-func (r *repository) GetOrder(orderID, userID int) (*Order, error) {
+// OrderItemRequest позиция нового заказа. Discount можно не указывать
+type OrderItemRequest struct {
+	SKU       string      `json:"sku"`
+	Name      string      `json:"name"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+	Discount  money.Money `json:"discount"`
+}
+
+const (
+	// maxOrderItems ограничивает число позиций в одном заказе
+	maxOrderItems = 100
+	maxQuantity   = 1000000
+)
+
+// orderColumns столбцы заказа в порядке, который ожидает scanOrder
+const orderColumns = `id, user_id, title, description, price, currency, status, created_at, updated_at`
+
+// StatusChange запись истории статусов заказа. FromStatus пуст у записи о
+// создании заказа, ChangedBy пуст, если пользователь удален
+type StatusChange struct {
+	ID         int       `json:"id"`
+	FromStatus *string   `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  *int      `json:"changed_by"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CancelOrderRequest отмена заказа владельцем. UpdatedAt это updated_at
+// заказа, который видел клиент: если заказ с тех пор изменился, отмена
+// отклоняется
+type CancelOrderRequest struct {
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TransitionOrderRequest смена статуса администратором
+type TransitionOrderRequest struct {
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Reason    string    `json:"reason"`
+}
+
+func (r *CreateOrderRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("title", r.Title)
+
+	if len(r.Items) == 0 {
+		if r.Price.IsZero() {
+			fields.Add("price", "required", "is required")
+		} else if r.Price.Amount <= 0 {
+			fields.Add("price", "not_positive", "must be positive")
+		}
+		return fields.Err()
+	}
+
+	if !r.Price.IsZero() {
+		fields.Add("price", "computed", "must be omitted when items are given")
+	}
+	if len(r.Items) > maxOrderItems {
+		fields.Add("items", "too_many", fmt.Sprintf("must contain at most %d items", maxOrderItems))
+		return fields.Err()
+	}
+	// Валюту заказа задает первая позиция, остальные должны с ней совпадать
+	currency := r.Items[0].UnitPrice.Currency
+	for i, item := range r.Items {
+		item.validate(&fields, fmt.Sprintf("items[%d].", i), currency)
+	}
+	if len(fields) == 0 {
+		total, err := itemsTotal(r.Items)
+		if err != nil {
+			fields.Add("items", "too_large", "order total is too large")
+		} else if total.Amount <= 0 {
+			fields.Add("items", "not_positive", "order total must be positive")
+		}
+	}
+	return fields.Err()
+}
+
+func (r *OrderItemRequest) validate(fields *apierror.Fields, prefix, currency string) {
+	fields.Required(prefix+"sku", r.SKU)
+	if len(r.SKU) > 64 {
+		fields.Add(prefix+"sku", "too_long", "must be at most 64 characters")
+	}
+	fields.Required(prefix+"name", r.Name)
+	if len(r.Name) > 255 {
+		fields.Add(prefix+"name", "too_long", "must be at most 255 characters")
+	}
+	if r.Quantity <= 0 {
+		fields.Add(prefix+"quantity", "not_positive", "must be positive")
+	} else if r.Quantity > maxQuantity {
+		fields.Add(prefix+"quantity", "too_large", fmt.Sprintf("must be at most %d", maxQuantity))
+	}
+
+	switch {
+	case r.UnitPrice.IsZero():
+		fields.Add(prefix+"unit_price", "required", "is required")
+	case r.UnitPrice.Amount < 0:
+		fields.Add(prefix+"unit_price", "negative", "must not be negative")
+	case r.UnitPrice.Currency != currency:
+		fields.Add(prefix+"unit_price", "currency_mismatch", "must be in "+currency+" like the other items")
+	}
+
+	// Количество и цена ограничены по отдельности, но их произведение тоже
+	// должно помещаться в столбцы заказа
+	subtotal, err := r.UnitPrice.Mul(int64(r.Quantity))
+	if err != nil && r.Quantity <= maxQuantity && r.UnitPrice.Amount > 0 {
+		fields.Add(prefix+"quantity", "total_too_large", "quantity * unit_price is too large")
+	}
+
+	if r.Discount.IsZero() {
+		return
+	}
+	switch {
+	case r.Discount.Amount < 0:
+		fields.Add(prefix+"discount", "negative", "must not be negative")
+	case r.Discount.Currency != currency:
+		fields.Add(prefix+"discount", "currency_mismatch", "must be in "+currency+" like the other items")
+	case err == nil && r.Quantity > 0 && r.Discount.Amount > subtotal.Amount:
+		fields.Add(prefix+"discount", "too_large", "must not exceed quantity * unit_price")
+	}
+}
+
+func (r *CancelOrderRequest) Validate() error {
+	var fields apierror.Fields
+	if r.UpdatedAt.IsZero() {
+		fields.Add("updated_at", "required", "is required")
+	}
+	return fields.Err()
+}
+
+func (r *TransitionOrderRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("status", r.Status)
+	if r.Status != "" && !ValidStatus(r.Status) {
+		fields.Add("status", "unknown_status", "is not a known order status")
+	}
+	if r.UpdatedAt.IsZero() {
+		fields.Add("updated_at", "required", "is required")
+	}
+	if len(r.Reason) > 1000 {
+		fields.Add("reason", "too_long", "must be at most 1000 characters")
+	}
+	return fields.Err()
+}
+
+// rowScanner общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanOrder читает столбцы orderColumns. Цена приходит из DECIMAL текстом и
+// переводится в минимальные единицы без float64
+func scanOrder(row rowScanner) (*Order, error) {
 	var order Order
-	err := r.db.QueryRow(
-		`SELECT id, user_id, title, description, price, status, created_at, updated_at 
+	var price, currency string
+	err := row.Scan(
+		&order.ID, &order.UserID, &order.Title, &order.Description,
+		&price, &currency, &order.Status, &order.CreatedAt, &order.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Price, err = money.FromDecimal(price, currency); err != nil {
+		return nil, fmt.Errorf("invalid price of order %d: %w", order.ID, err)
+	}
+	return &order, nil
+}
+
+func (r *repository) GetOrder(orderID, userID int) (*Order, error) {
+	order, err := scanOrder(r.db.QueryRow(
+		`SELECT `+orderColumns+`
 		 FROM orders 
 		 WHERE id = $1 AND user_id = $2`,
 		orderID, userID,
-	).Scan(
-		&order.ID, &order.UserID, &order.Title, &order.Description,
-		&order.Price, &order.Status, &order.CreatedAt, &order.UpdatedAt,
-	)
+	))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -55,50 +252,250 @@ func (r *repository) GetOrder(orderID, userID int) (*Order, error) {
 		return nil, err
 	}
 
-	return &order, nil
+	return order, nil
 }
 
+// CreateOrder создает заказ вместе с первой записью в истории статусов
 func (r *repository) CreateOrder(order *Order) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	var id int
-	err := r.db.QueryRow(
-		`INSERT INTO orders (user_id, title, description, price, status) 
-		 VALUES ($1, $2, $3, $4, $5) 
+	err = tx.QueryRow(
+		`INSERT INTO orders (user_id, title, description, price, currency, status) 
+		 VALUES ($1, $2, $3, $4, $5, $6) 
 		 RETURNING id, created_at, updated_at`,
-		order.UserID, order.Title, order.Description, order.Price, "pending",
+		order.UserID, order.Title, order.Description, order.Price.Decimal(), order.Price.Currency, StatusPending,
 	).Scan(&id, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return 0, err
+	}
 
-	return id, err
+	for i := range order.Items {
+		item := &order.Items[i]
+		err = tx.QueryRow(
+			`INSERT INTO order_items (order_id, sku, name, quantity, unit_price, discount)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 RETURNING id`,
+			id, item.SKU, item.Name, item.Quantity, item.UnitPrice.Decimal(), item.Discount.Decimal(),
+		).Scan(&item.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO order_status_history (order_id, to_status, changed_by)
+		 VALUES ($1, $2, $3)`,
+		id, StatusPending, order.UserID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
 }
 
-func (r *repository) GetUserOrders(userID int) ([]Order, error) {
+func (r *repository) GetOrderItems(orderID int) ([]OrderItem, error) {
 	rows, err := r.db.Query(
-		`SELECT id, user_id, title, description, price, status, created_at, updated_at 
-		 FROM orders 
-		 WHERE user_id = $1 
-		 ORDER BY created_at DESC`,
-		userID,
+		`SELECT i.id, i.sku, i.name, i.quantity, i.unit_price, i.discount, o.currency
+		 FROM order_items i
+		 JOIN orders o ON o.id = i.order_id
+		 WHERE i.order_id = $1
+		 ORDER BY i.id`,
+		orderID,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var orders []Order
+	var items []OrderItem
 	for rows.Next() {
-		var order Order
-		err := rows.Scan(
-			&order.ID, &order.UserID, &order.Title, &order.Description,
-			&order.Price, &order.Status, &order.CreatedAt, &order.UpdatedAt,
-		)
+		var item OrderItem
+		var unitPrice, discount, currency string
+		err := rows.Scan(&item.ID, &item.SKU, &item.Name, &item.Quantity, &unitPrice, &discount, &currency)
 		if err != nil {
 			return nil, err
 		}
-		orders = append(orders, order)
+		if item.UnitPrice, err = money.FromDecimal(unitPrice, currency); err != nil {
+			return nil, fmt.Errorf("invalid unit price of order item %d: %w", item.ID, err)
+		}
+		if item.Discount, err = money.FromDecimal(discount, currency); err != nil {
+			return nil, fmt.Errorf("invalid discount of order item %d: %w", item.ID, err)
+		}
+		if item.Total, err = lineTotal(item.UnitPrice, item.Quantity, item.Discount); err != nil {
+			return nil, fmt.Errorf("invalid total of order item %d: %w", item.ID, err)
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (r *repository) GetOrderByID(orderID int) (*Order, error) {
+	order, err := scanOrder(r.db.QueryRow(
+		`SELECT `+orderColumns+`
+		 FROM orders
+		 WHERE id = $1`,
+		orderID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if err = rows.Err(); err != nil {
+	return order, nil
+}
+
+// UpdateOrderStatus переводит заказ из from в to и записывает переход в
+// историю. Заказ обновляется, только если его статус и updated_at не
+// изменились с момента чтения, иначе возвращается nil
+func (r *repository) UpdateOrderStatus(orderID int, from, to string, updatedAt time.Time, changedBy int, reason string) (*Order, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	order, err := scanOrder(tx.QueryRow(
+		`UPDATE orders
+		 SET status = $1, updated_at = NOW()
+		 WHERE id = $2 AND status = $3 AND updated_at = $4
+		 RETURNING `+orderColumns,
+		to, orderID, from, updatedAt,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO order_status_history (order_id, from_status, to_status, changed_by, reason)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		orderID, from, to, changedBy, reason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (r *repository) GetOrderHistory(orderID int) ([]StatusChange, error) {
+	rows, err := r.db.Query(
+		`SELECT id, from_status, to_status, changed_by, reason, created_at
+		 FROM order_status_history
+		 WHERE order_id = $1
+		 ORDER BY created_at, id`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []StatusChange{}
+	for rows.Next() {
+		var change StatusChange
+		var fromStatus sql.NullString
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&change.ID, &fromStatus, &change.ToStatus, &changedBy, &change.Reason, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		if fromStatus.Valid {
+			change.FromStatus = &fromStatus.String
+		}
+		if changedBy.Valid {
+			id := int(changedBy.Int64)
+			change.ChangedBy = &id
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// ListUserOrders страница заказов пользователя и общее число заказов,
+// подходящих под фильтр. Страницы разбиваются по ключу (столбец сортировки,
+// id), поэтому заказы, созданные между запросами, не сдвигают страницы
+func (r *repository) ListUserOrders(userID int, filter OrderFilter) ([]Order, int, error) {
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "status = ANY("+arg(pq.Array(filter.Statuses))+")")
+	}
+	if filter.Currency != "" {
+		conditions = append(conditions, "currency = "+arg(filter.Currency))
+	}
+	if filter.MinPrice != nil {
+		conditions = append(conditions, "price >= "+arg(filter.MinPrice.Decimal()))
+	}
+	if filter.MaxPrice != nil {
+		conditions = append(conditions, "price <= "+arg(filter.MaxPrice.Decimal()))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(filter.CreatedTo))
+	}
+
+	var total int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM orders WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	column, cast, direction, compare := "created_at", "timestamp", "DESC", "<"
+	switch filter.Sort {
+	case SortCreatedAtAsc:
+		direction, compare = "ASC", ">"
+	case SortPriceDesc:
+		column, cast = "price", "numeric"
+	case SortPriceAsc:
+		column, cast, direction, compare = "price", "numeric", "ASC", ">"
+	}
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			column, compare, arg(filter.After.Value), cast, arg(filter.After.ID)))
+	}
+
+	rows, err := r.db.Query(
+		fmt.Sprintf("SELECT %s FROM orders WHERE %s ORDER BY %s %s, id %s LIMIT %s",
+			orderColumns, strings.Join(conditions, " AND "), column, direction, direction, arg(filter.Limit)),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, *order)
+	}
 
-	return orders, nil
+	return orders, total, rows.Err()
 }
@@ -1,9 +1,20 @@
 package order
 
+import (
+	"fmt"
+	"time"
+
+	"auth-user-service/internal/money"
+)
+
 type Service interface {
 	GetOrder(orderID, userID int) (*Order, error)
-	CreateOrder(userID int, title, description string, price float64) (*Order, error)
-	GetUserOrders(userID int) ([]Order, error)
+	CreateOrder(userID int, req CreateOrderRequest) (*Order, error)
+	ListUserOrders(userID int, filter OrderFilter) (*OrderPage, error)
+
+	CancelOrder(orderID, userID int, updatedAt time.Time) (*Order, error)
+	TransitionOrder(orderID int, status string, updatedAt time.Time, changedBy int, reason string) (*Order, error)
+	GetOrderHistory(orderID, userID int) ([]StatusChange, error)
 }
 
 type service struct {
@@ -14,17 +25,56 @@ func NewService(repo Repository) Service {
 	return &service{repo: repo}
 }
 
+// GetOrder заказ пользователя вместе с позициями
 func (s *service) GetOrder(orderID, userID int) (*Order, error) {
-	return s.repo.GetOrder(orderID, userID)
+	order, err := s.repo.GetOrder(orderID, userID)
+	if err != nil || order == nil {
+		return order, err
+	}
+
+	order.Items, err = s.repo.GetOrderItems(order.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order items: %w", err)
+	}
+	return order, nil
 }
 
-func (s *service) CreateOrder(userID int, title, description string, price float64) (*Order, error) {
+// CreateOrder создает заказ. Стоимость заказа из позиций считается здесь,
+// присланной клиентом цене не доверяем. Заказ и позиции сохраняются в одной
+// транзакции
+func (s *service) CreateOrder(userID int, req CreateOrderRequest) (*Order, error) {
 	order := &Order{
 		UserID:      userID,
-		Title:       title,
-		Description: description,
-		Price:       price,
-		Status:      "pending",
+		Title:       req.Title,
+		Description: req.Description,
+		Price:       req.Price,
+		Status:      StatusPending,
+	}
+
+	if len(req.Items) > 0 {
+		order.Items = make([]OrderItem, len(req.Items))
+		for i, item := range req.Items {
+			total, err := lineTotal(item.UnitPrice, item.Quantity, item.Discount)
+			if err != nil {
+				return nil, err
+			}
+			order.Items[i] = OrderItem{
+				SKU:       item.SKU,
+				Name:      item.Name,
+				Quantity:  item.Quantity,
+				UnitPrice: item.UnitPrice,
+				Discount:  item.Discount,
+				Total:     total,
+			}
+			if item.Discount.IsZero() {
+				order.Items[i].Discount = money.New(0, item.UnitPrice.Currency)
+			}
+		}
+
+		var err error
+		if order.Price, err = itemsTotal(req.Items); err != nil {
+			return nil, err
+		}
 	}
 
 	id, err := s.repo.CreateOrder(order)
@@ -36,6 +86,117 @@ func (s *service) CreateOrder(userID int, title, description string, price float
 	return order, nil
 }
 
-func (s *service) GetUserOrders(userID int) ([]Order, error) {
-	return s.repo.GetUserOrders(userID)
+// ListUserOrders страница заказов пользователя. Из базы читается на один
+// заказ больше, чтобы узнать, есть ли следующая страница
+func (s *service) ListUserOrders(userID int, filter OrderFilter) (*OrderPage, error) {
+	limit := filter.Limit
+	filter.Limit++
+
+	orders, total, err := s.repo.ListUserOrders(userID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+
+	page := &OrderPage{Orders: orders, Total: total}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		page.NextCursor = cursorAfter(page.Orders[limit-1], filter.Sort).Encode()
+	}
+	return page, nil
+}
+
+// CancelOrder отменяет заказ по просьбе владельца. Владелец может отменить
+// заказ, только пока его не начали обрабатывать
+func (s *service) CancelOrder(orderID, userID int, updatedAt time.Time) (*Order, error) {
+	order, err := s.repo.GetOrder(orderID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if order == nil {
+		return nil, ErrOrderNotFound
+	}
+	if order.Status != StatusPending {
+		return nil, ErrOrderNotCancelable
+	}
+
+	return s.updateStatus(order, StatusCancelled, updatedAt, userID, "")
+}
+
+// TransitionOrder переводит заказ в новый статус от имени администратора
+func (s *service) TransitionOrder(orderID int, status string, updatedAt time.Time, changedBy int, reason string) (*Order, error) {
+	if !ValidStatus(status) {
+		return nil, ErrUnknownStatus
+	}
+
+	order, err := s.repo.GetOrderByID(orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if order == nil {
+		return nil, ErrOrderNotFound
+	}
+	if !CanTransition(order.Status, status) {
+		return nil, ErrInvalidTransition
+	}
+
+	return s.updateStatus(order, status, updatedAt, changedBy, reason)
+}
+
+// GetOrderHistory история статусов заказа пользователя
+func (s *service) GetOrderHistory(orderID, userID int) ([]StatusChange, error) {
+	order, err := s.repo.GetOrder(orderID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if order == nil {
+		return nil, ErrOrderNotFound
+	}
+
+	history, err := s.repo.GetOrderHistory(orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order history: %w", err)
+	}
+	return history, nil
+}
+
+// lineTotal стоимость позиции с учетом скидки. Сумма, не помещающаяся в
+// столбец, возвращается ошибкой money.ErrAmountTooLarge
+func lineTotal(unitPrice money.Money, quantity int, discount money.Money) (money.Money, error) {
+	total, err := unitPrice.Mul(int64(quantity))
+	if err != nil || discount.IsZero() {
+		return total, err
+	}
+	return total.Add(money.New(-discount.Amount, discount.Currency))
+}
+
+// itemsTotal стоимость заказа. Валюты позиций проверены при валидации запроса
+func itemsTotal(items []OrderItemRequest) (money.Money, error) {
+	total := money.New(0, items[0].UnitPrice.Currency)
+	for _, item := range items {
+		line, err := lineTotal(item.UnitPrice, item.Quantity, item.Discount)
+		if err != nil {
+			return money.Money{}, err
+		}
+		if total, err = total.Add(line); err != nil {
+			return money.Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// updateStatus сохраняет переход. Если клиент прислал устаревший updated_at
+// или заказ успели изменить параллельно, возвращается ErrOrderModified
+func (s *service) updateStatus(order *Order, status string, updatedAt time.Time, changedBy int, reason string) (*Order, error) {
+	if !order.UpdatedAt.Equal(updatedAt) {
+		return nil, ErrOrderModified
+	}
+
+	updated, err := s.repo.UpdateOrderStatus(order.ID, order.Status, status, order.UpdatedAt, changedBy, reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update order status: %w", err)
+	}
+	if updated == nil {
+		return nil, ErrOrderModified
+	}
+	return updated, nil
 }
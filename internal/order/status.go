@@ -0,0 +1,46 @@
+package order
+
+import "auth-user-service/internal/apierror"
+
+// Статусы заказа, совпадают с CHECK в таблице orders
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusCancelled  = "cancelled"
+)
+
+// transitions допустимые переходы между статусами. completed и cancelled
+// конечные: из них перейти нельзя
+var transitions = map[string][]string{
+	StatusPending:    {StatusProcessing, StatusCancelled},
+	StatusProcessing: {StatusCompleted, StatusCancelled},
+}
+
+var (
+	ErrOrderNotFound      = apierror.NotFound("order_not_found", "Order not found")
+	ErrUnknownStatus      = apierror.Validation("unknown_status", "Unknown order status")
+	ErrInvalidTransition  = apierror.Conflict("invalid_status_transition", "Order cannot move to this status")
+	ErrOrderNotCancelable = apierror.Conflict("order_not_cancelable", "Only pending orders can be cancelled")
+	// ErrOrderModified заказ изменился после того, как клиент его прочитал
+	ErrOrderModified = apierror.Conflict("order_modified", "Order was modified, reload it and try again")
+)
+
+// ValidStatus сообщает, известен ли статус
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusProcessing, StatusCompleted, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// CanTransition сообщает, можно ли перевести заказ из from в to
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
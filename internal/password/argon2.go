@@ -0,0 +1,103 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2Params параметры argon2id. Memory в KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// withDefaults подставляет значения по умолчанию (64 MiB, 3 прохода,
+// 2 потока) вместо нулевых
+func (p Argon2Params) withDefaults() Argon2Params {
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Iterations == 0 {
+		p.Iterations = 3
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = 2
+	}
+	return p
+}
+
+// weakerThan сравнивает стоимость перебора. Число потоков на нее почти не
+// влияет, поэтому не учитывается
+func (p Argon2Params) weakerThan(other Argon2Params) bool {
+	return p.Memory < other.Memory || p.Iterations < other.Iterations
+}
+
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2id, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func verifyArgon2id(password, encoded string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// decodeArgon2id разбирает строку PHC вида
+// $argon2id$v=19$m=65536,t=3,p=2$<соль>$<хэш>
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: argon2 version %q", ErrUnsupportedHash, parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnsupportedHash, parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2 salt", ErrUnsupportedHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("%w: argon2 hash", ErrUnsupportedHash)
+	}
+
+	return params, salt, key, nil
+}
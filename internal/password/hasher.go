@@ -0,0 +1,151 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Алгоритмы хэширования паролей
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+var (
+	ErrMismatch        = errors.New("password does not match")
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+)
+
+// Hasher хэширует пароли текущим алгоритмом и проверяет их по хэшу любого
+// поддерживаемого алгоритма, чтобы старые хэши продолжали работать
+type Hasher interface {
+	// Hash возвращает хэш в формате PHC ($argon2id$v=19$m=...,t=...,p=...$salt$hash)
+	// или в модульном формате crypt для bcrypt ($2a$10$...)
+	Hash(password string) (string, error)
+	// Verify возвращает ErrMismatch, если пароль не совпадает с хэшем
+	Verify(password, encoded string) error
+	// NeedsRehash сообщает, что хэш получен другим алгоритмом или с более
+	// слабыми параметрами, чем текущие
+	NeedsRehash(encoded string) bool
+}
+
+// Config алгоритм для новых хэшей и его параметры. Нулевые значения
+// заменяются значениями по умолчанию. MaxConcurrent ограничивает число
+// одновременных вычислений хэша (по умолчанию число CPU): каждое вычисление
+// argon2id занимает Argon2.Memory памяти, и поток входов не должен
+// исчерпывать память процесса
+type Config struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2        Argon2Params
+	MaxConcurrent int
+}
+
+type hasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     Argon2Params
+	slots      chan struct{}
+}
+
+func NewHasher(cfg Config) (Hasher, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = Argon2id
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = bcrypt.DefaultCost
+	}
+	cfg.Argon2 = cfg.Argon2.withDefaults()
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = runtime.NumCPU()
+	}
+
+	switch cfg.Algorithm {
+	case Argon2id, Bcrypt:
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	return &hasher{
+		algorithm:  cfg.Algorithm,
+		bcryptCost: cfg.BcryptCost,
+		argon2:     cfg.Argon2,
+		slots:      make(chan struct{}, cfg.MaxConcurrent),
+	}, nil
+}
+
+// acquire ждет свободного места для вычисления хэша. Освобождается release
+func (h *hasher) acquire() {
+	h.slots <- struct{}{}
+}
+
+func (h *hasher) release() {
+	<-h.slots
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	h.acquire()
+	defer h.release()
+
+	if h.algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+	return hashArgon2id(password, h.argon2)
+}
+
+func (h *hasher) Verify(password, encoded string) error {
+	switch {
+	case isBcrypt(encoded):
+		h.acquire()
+		defer h.release()
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	case isArgon2id(encoded):
+		h.acquire()
+		defer h.release()
+		return verifyArgon2id(password, encoded)
+	default:
+		return ErrUnsupportedHash
+	}
+}
+
+func (h *hasher) NeedsRehash(encoded string) bool {
+	switch {
+	case isBcrypt(encoded):
+		if h.algorithm != Bcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err == nil && cost < h.bcryptCost
+	case isArgon2id(encoded):
+		if h.algorithm != Argon2id {
+			return true
+		}
+		params, _, _, err := decodeArgon2id(encoded)
+		return err == nil && params.weakerThan(h.argon2)
+	default:
+		// Пустой или непригодный хэш (пароль не задан) не обновляется
+		return false
+	}
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func isArgon2id(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+Argon2id+"$")
+}
@@ -0,0 +1,111 @@
+package rbac
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"auth-user-service/internal/apierror"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.service.ListRoles()
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, roles, http.StatusOK)
+}
+
+func (h *Handler) ListUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	roles, err := h.service.ListUserRoles(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, roles, http.StatusOK)
+}
+
+func (h *Handler) GrantRole(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	var req GrantRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+	if req.Role == "" {
+		h.writeError(w, apierror.MissingField("role"))
+		return
+	}
+
+	if err := h.service.GrantRole(userID, req.Role, adminID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	roles, err := h.service.ListUserRoles(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, roles, http.StatusOK)
+}
+
+func (h *Handler) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, apierror.ErrInvalidID)
+		return
+	}
+
+	if err := h.service.RevokeRole(userID, chi.URLParam(r, "role")); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Вспомогательные методы
+func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// writeError отвечает ошибкой в формате application/problem+json
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	apierror.Write(w, err)
+}
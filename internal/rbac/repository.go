@@ -0,0 +1,202 @@
+package rbac
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Repository interface {
+	ListRoles() ([]Role, error)
+	GetRole(name string) (*Role, error)
+	ListUserRoles(userID int) ([]UserRole, error)
+	GrantRole(userID, roleID int, grantedBy *int) error
+	RevokeRole(userID int, role *Role) (bool, error)
+	FindUserID(email string) (int, bool, error)
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return &repository{db: db}
+}
+
+// Role роль и выданные ей права
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserRole назначение роли пользователю
+type UserRole struct {
+	Role      string    `json:"role"`
+	GrantedBy *int      `json:"granted_by,omitempty"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+type GrantRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (r *repository) ListRoles() ([]Role, error) {
+	rows, err := r.db.Query(
+		`SELECT r.id, r.name, r.description,
+		        COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		 FROM roles r
+		 LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		 LEFT JOIN permissions p ON p.id = rp.permission_id
+		 GROUP BY r.id
+		 ORDER BY r.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *repository) GetRole(name string) (*Role, error) {
+	var role Role
+	err := r.db.QueryRow(
+		`SELECT r.id, r.name, r.description,
+		        COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		 FROM roles r
+		 LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		 LEFT JOIN permissions p ON p.id = rp.permission_id
+		 WHERE r.name = $1
+		 GROUP BY r.id`,
+		name,
+	).Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (r *repository) ListUserRoles(userID int) ([]UserRole, error) {
+	rows, err := r.db.Query(
+		`SELECT r.name, ur.granted_by, ur.granted_at
+		 FROM user_roles ur
+		 JOIN roles r ON r.id = ur.role_id
+		 WHERE ur.user_id = $1
+		 ORDER BY r.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []UserRole{}
+	for rows.Next() {
+		var role UserRole
+		var grantedBy sql.NullInt64
+		if err := rows.Scan(&role.Role, &grantedBy, &role.GrantedAt); err != nil {
+			return nil, err
+		}
+		if grantedBy.Valid {
+			id := int(grantedBy.Int64)
+			role.GrantedBy = &id
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// GrantRole назначает роль. Повторное назначение ничего не меняет
+func (r *repository) GrantRole(userID, roleID int, grantedBy *int) error {
+	_, err := r.db.Exec(
+		`INSERT INTO user_roles (user_id, role_id, granted_by) VALUES ($1, $2, $3)
+		 ON CONFLICT (user_id, role_id) DO NOTHING`,
+		userID, roleID, grantedBy,
+	)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// RevokeRole снимает роль. Последнего администратора снять нельзя, иначе
+// управлять ролями станет некому. Возвращает false, если роли у
+// пользователя не было
+func (r *repository) RevokeRole(userID int, role *Role) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка роли упорядочивает одновременные снятия
+	if _, err := tx.Exec("SELECT id FROM roles WHERE id = $1 FOR UPDATE", role.ID); err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(
+		"DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2",
+		userID, role.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	if role.Name == AdminRole {
+		var remaining int
+		err := tx.QueryRow("SELECT COUNT(*) FROM user_roles WHERE role_id = $1", role.ID).Scan(&remaining)
+		if err != nil {
+			return false, err
+		}
+		if remaining == 0 {
+			return false, ErrLastAdmin
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// FindUserID возвращает id пользователя по email или 0, если его нет, и
+// подтвержден ли его email
+func (r *repository) FindUserID(email string) (int, bool, error) {
+	var userID int
+	var verified bool
+	err := r.db.QueryRow(
+		"SELECT id, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1)",
+		email,
+	).Scan(&userID, &verified)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return userID, verified, nil
+}
@@ -0,0 +1,126 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"auth-user-service/internal/apierror"
+)
+
+// AdminRole роль, которая выдается администраторам из конфигурации
+const AdminRole = "admin"
+
+var (
+	ErrRoleNotFound   = apierror.NotFound("role_not_found", "Role not found")
+	ErrRoleNotGranted = apierror.NotFound("role_not_granted", "Role not granted")
+	ErrUserNotFound   = apierror.NotFound("user_not_found", "User not found")
+	ErrLastAdmin      = apierror.Conflict("last_admin", "Cannot revoke the last admin")
+)
+
+// TokenRevoker отзывает access токены пользователя (auth.RevocationStore)
+type TokenRevoker interface {
+	RevokeUserTokens(ctx context.Context, userID int, before time.Time) error
+}
+
+type Service interface {
+	ListRoles() ([]Role, error)
+	ListUserRoles(userID int) ([]UserRole, error)
+	GrantRole(userID int, role string, grantedBy int) error
+	RevokeRole(userID int, role string) error
+	BootstrapAdmins(emails []string) error
+}
+
+type service struct {
+	repo    Repository
+	revoker TokenRevoker
+}
+
+func NewService(repo Repository, revoker TokenRevoker) Service {
+	return &service{repo: repo, revoker: revoker}
+}
+
+func (s *service) ListRoles() ([]Role, error) {
+	return s.repo.ListRoles()
+}
+
+func (s *service) ListUserRoles(userID int) ([]UserRole, error) {
+	return s.repo.ListUserRoles(userID)
+}
+
+// GrantRole назначает роль. Она появится в токенах пользователя после
+// следующего обновления
+func (s *service) GrantRole(userID int, name string, grantedBy int) error {
+	role, err := s.repo.GetRole(name)
+	if err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	if role == nil {
+		return ErrRoleNotFound
+	}
+
+	return s.repo.GrantRole(userID, role.ID, &grantedBy)
+}
+
+// RevokeRole снимает роль и отзывает выданные access токены, чтобы права не
+// оставались у пользователя до их истечения. Refresh токены продолжают
+// работать, новые access токены выдаются уже без роли
+func (s *service) RevokeRole(userID int, name string) error {
+	role, err := s.repo.GetRole(name)
+	if err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	if role == nil {
+		return ErrRoleNotFound
+	}
+
+	revoked, err := s.repo.RevokeRole(userID, role)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrRoleNotGranted
+	}
+
+	if err := s.revoker.RevokeUserTokens(context.Background(), userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	return nil
+}
+
+// BootstrapAdmins выдает роль администратора пользователям из конфигурации,
+// чтобы было кому назначать роли остальным. Незарегистрированные и
+// неподтвержденные email пропускаются: роль будет выдана при следующем
+// запуске. Иначе администратором стал бы любой, кто первым зарегистрирует
+// этот адрес
+func (s *service) BootstrapAdmins(emails []string) error {
+	role, err := s.repo.GetRole(AdminRole)
+	if err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	if role == nil {
+		return ErrRoleNotFound
+	}
+
+	for _, email := range emails {
+		userID, verified, err := s.repo.FindUserID(email)
+		if err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+		if userID == 0 {
+			log.Printf("⚠️ Admin %s is not registered yet", email)
+			continue
+		}
+		if !verified {
+			log.Printf("⚠️ Admin %s has not confirmed the email yet", email)
+			continue
+		}
+
+		if err := s.repo.GrantRole(userID, role.ID, nil); err != nil {
+			return fmt.Errorf("failed to grant admin role: %w", err)
+		}
+	}
+
+	return nil
+}
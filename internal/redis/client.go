@@ -3,12 +3,16 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrNotFound возвращается Get, если ключ отсутствует
+var ErrNotFound = errors.New("redis: key not found")
+
 type Client struct {
 	client *redis.Client
 }
@@ -45,12 +49,125 @@ func (c *Client) Set(ctx context.Context, key string, value interface{}, expirat
 
 func (c *Client) Get(ctx context.Context, key string, dest interface{}) error {
 	val, err := c.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
 	return json.Unmarshal([]byte(val), dest)
 }
 
+// SetNX записывает значение, только если ключа еще нет. Возвращает false,
+// если ключ уже занят
+func (c *Client) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return c.client.SetNX(ctx, key, jsonValue, expiration).Result()
+}
+
+// GetDel атомарно читает и удаляет значение, например одноразовый challenge
+func (c *Client) GetDel(ctx context.Context, key string, dest interface{}) error {
+	val, err := c.client.GetDel(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(val), dest)
+}
+
+// Incr атомарно увеличивает счетчик и продлевает его время жизни
+func (c *Client) Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, expiration)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// decrScript уменьшает счетчик, только если он существует: DECR на
+// истекшем ключе создал бы его заново без времени жизни
+var decrScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// Decr атомарно уменьшает существующий счетчик
+func (c *Client) Decr(ctx context.Context, key string) (int64, error) {
+	return decrScript.Run(ctx, c.client, []string{key}).Int64()
+}
+
+// setMaxScript записывает число, только если оно больше сохраненного
+var setMaxScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]))
+if current == nil or current < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// SetMax атомарно записывает value, если ключа нет или в нем меньшее число.
+// Значение читается через Get как обычное число
+func (c *Client) SetMax(ctx context.Context, key string, value int64, expiration time.Duration) error {
+	return setMaxScript.Run(ctx, c.client, []string{key}, value, expiration.Milliseconds()).Err()
+}
+
+// deleteIfEqualScript удаляет ключ, только если в нем лежит ожидаемое значение
+var deleteIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// DeleteIfEqual атомарно удаляет ключ, если его значение совпадает с value.
+// Возвращает false, если значение другое или ключа нет
+func (c *Client) DeleteIfEqual(ctx context.Context, key string, value interface{}) (bool, error) {
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := deleteIfEqualScript.Run(ctx, c.client, []string{key}, jsonValue).Int64()
+	return deleted == 1, err
+}
+
+// setIfEqualScript перезаписывает ключ, только если в нем лежит ожидаемое
+// значение
+var setIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// SetIfEqual атомарно заменяет значение ключа на value, если текущее
+// совпадает с expected. Возвращает false, если значение другое или ключа нет
+func (c *Client) SetIfEqual(ctx context.Context, key string, expected, value interface{}, expiration time.Duration) (bool, error) {
+	jsonExpected, err := json.Marshal(expected)
+	if err != nil {
+		return false, err
+	}
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	set, err := setIfEqualScript.Run(ctx, c.client, []string{key}, jsonExpected, jsonValue, expiration.Milliseconds()).Int64()
+	return set == 1, err
+}
+
 func (c *Client) Delete(ctx context.Context, key string) error {
 	return c.client.Del(ctx, key).Err()
 }
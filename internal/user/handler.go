@@ -2,7 +2,12 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/auth"
+	"auth-user-service/internal/validator"
 )
 
 type Handler struct {
@@ -13,20 +18,21 @@ func NewHandler(service Service) *Handler {
 	return &Handler{service: service}
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
+var (
+	errWrongPassword = apierror.Unauthorized("invalid_credentials", "Current password is incorrect")
+	errEmailInUse    = apierror.Conflict("email_in_use", "Email is already in use")
+)
 
 func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
-		h.writeError(w, "User not authenticated", http.StatusUnauthorized)
+		h.writeError(w, apierror.ErrUnauthenticated)
 		return
 	}
 
 	profile, err := h.service.GetProfile(userID)
 	if err != nil {
-		h.writeError(w, "Failed to get profile", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
@@ -45,13 +51,13 @@ func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
-		h.writeError(w, "User not authenticated", http.StatusUnauthorized)
+		h.writeError(w, apierror.ErrUnauthenticated)
 		return
 	}
 
 	var req UpdateProfileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, "Invalid request", http.StatusBadRequest)
+		h.writeError(w, apierror.ErrInvalidJSON)
 		return
 	}
 
@@ -64,13 +70,126 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 
 	err := h.service.UpdateProfile(userID, profile)
 	if err != nil {
-		h.writeError(w, "Failed to update profile", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
 	h.writeJSON(w, map[string]string{"status": "profile updated"}, http.StatusOK)
 }
 
+// ChangePassword меняет пароль. Остальные сессии пользователя завершаются
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("tokenClaims").(*auth.Claims)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	warnings, err := h.service.ChangePassword(claims.UserID, req.CurrentPassword, req.NewPassword, claims.SessionID)
+	if err != nil {
+		var locked *auth.LockedError
+		if errors.As(err, &locked) {
+			auth.WriteLocked(w, locked)
+			return
+		}
+		var policyErr *validator.PasswordPolicyError
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			err = errWrongPassword
+		case errors.As(err, &policyErr):
+			err = policyErr.APIError("new_password")
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Password changed, other sessions have been signed out",
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	h.writeJSON(w, response, http.StatusOK)
+}
+
+// ChangeEmail отправляет ссылку для подтверждения на новый адрес
+func (h *Handler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		h.writeError(w, apierror.ErrUnauthenticated)
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, apierror.ErrInvalidJSON)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	if err := h.service.RequestEmailChange(userID, req.CurrentPassword, req.Email); err != nil {
+		var locked *auth.LockedError
+		if errors.As(err, &locked) {
+			auth.WriteLocked(w, locked)
+			return
+		}
+		switch {
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			err = errWrongPassword
+		case errors.Is(err, auth.ErrUserExists):
+			err = errEmailInUse
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string]string{"message": "A confirmation link has been sent to the new address"}, http.StatusAccepted)
+}
+
+// ConfirmEmailChange подтверждает новый email по ссылке из письма (GET
+// ?token=) или от фронтенда (POST {"token": "..."})
+func (h *Handler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var req ConfirmEmailChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, apierror.ErrInvalidJSON)
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		h.writeError(w, apierror.MissingField("token"))
+		return
+	}
+
+	if err := h.service.ConfirmEmailChange(token); err != nil {
+		if errors.Is(err, auth.ErrUserExists) {
+			err = errEmailInUse
+		}
+		h.writeError(w, err)
+		return
+	}
+
+	h.writeJSON(w, map[string]string{"message": "Email address has been changed"}, http.StatusOK)
+}
+
 // Вспомогательные методы
 func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -80,6 +199,7 @@ func (h *Handler) writeJSON(w http.ResponseWriter, data interface{}, statusCode
 	}
 }
 
-func (h *Handler) writeError(w http.ResponseWriter, message string, statusCode int) {
-	h.writeJSON(w, ErrorResponse{Error: message}, statusCode)
+// writeError отвечает ошибкой в формате application/problem+json
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	apierror.Write(w, err)
 }
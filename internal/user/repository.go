@@ -3,6 +3,9 @@ package user
 import (
 	"database/sql"
 	"time"
+
+	"auth-user-service/internal/apierror"
+	"auth-user-service/internal/validator"
 )
 
 type Repository interface {
@@ -36,6 +39,37 @@ type UpdateProfileRequest struct {
 	Address   string `json:"address"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Email           string `json:"email"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("current_password", r.CurrentPassword)
+	fields.Required("new_password", r.NewPassword)
+	return fields.Err()
+}
+
+func (r *ChangeEmailRequest) Validate() error {
+	var fields apierror.Fields
+	fields.Required("current_password", r.CurrentPassword)
+	fields.Required("email", r.Email)
+	if r.Email != "" && !validator.ValidateEmail(r.Email) {
+		fields.Add("email", "invalid_email", "must be a valid email address")
+	}
+	return fields.Err()
+}
+
 func (r *repository) GetProfile(userID int) (*Profile, error) {
 	var profile Profile
 	err := r.db.QueryRow(
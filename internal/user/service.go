@@ -4,16 +4,23 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"auth-user-service/internal/auth"
+	"auth-user-service/internal/validator"
 )
 
 type Service interface {
 	GetProfile(userID int) (*Profile, error)
 	UpdateProfile(userID int, profile *Profile) error
+	ChangePassword(userID int, currentPassword, newPassword, currentSessionID string) ([]validator.PasswordViolation, error)
+	RequestEmailChange(userID int, currentPassword, newEmail string) error
+	ConfirmEmailChange(token string) error
 }
 
 type service struct {
-	repo  Repository
-	redis RedisClient
+	repo        Repository
+	redis       RedisClient
+	authService auth.Service
 }
 
 type RedisClient interface {
@@ -22,10 +29,11 @@ type RedisClient interface {
 	Delete(ctx context.Context, key string) error
 }
 
-func NewService(repo Repository, redisClient RedisClient) Service {
+func NewService(repo Repository, redisClient RedisClient, authService auth.Service) Service {
 	return &service{
-		repo:  repo,
-		redis: redisClient,
+		repo:        repo,
+		redis:       redisClient,
+		authService: authService,
 	}
 }
 
@@ -68,7 +76,35 @@ func (s *service) UpdateProfile(userID int, profile *Profile) error {
 		return fmt.Errorf("failed to update profile: %w", err)
 	}
 
-	// Инвалидируем кэш
+	s.invalidateCache(userID)
+
+	return nil
+}
+
+// ChangePassword меняет пароль и завершает остальные сессии пользователя
+func (s *service) ChangePassword(userID int, currentPassword, newPassword, currentSessionID string) ([]validator.PasswordViolation, error) {
+	return s.authService.ChangePassword(userID, currentPassword, newPassword, currentSessionID)
+}
+
+// RequestEmailChange отправляет ссылку для подтверждения на новый адрес
+func (s *service) RequestEmailChange(userID int, currentPassword, newEmail string) error {
+	return s.authService.RequestEmailChange(userID, currentPassword, newEmail)
+}
+
+// ConfirmEmailChange меняет email по ссылке из письма
+func (s *service) ConfirmEmailChange(token string) error {
+	userID, err := s.authService.ConfirmEmailChange(token)
+	if err != nil {
+		return err
+	}
+
+	// В кэшированном профиле остался старый email
+	s.invalidateCache(userID)
+
+	return nil
+}
+
+func (s *service) invalidateCache(userID int) {
 	if s.redis != nil {
 		cacheKey := fmt.Sprintf("user_profile:%d", userID)
 		ctx := context.Background()
@@ -77,6 +113,4 @@ func (s *service) UpdateProfile(userID int, profile *Profile) error {
 			fmt.Printf("Warning: failed to invalidate cache: %v\n", err)
 		}
 	}
-
-	return nil
 }
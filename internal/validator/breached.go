@@ -0,0 +1,162 @@
+package validator
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxCorpusLine длиннее строки базы не бывают: 40 символов хэша и счетчик
+const maxCorpusLine = 128
+
+// BreachedPasswords локальная база утекших паролей в формате Have I Been
+// Pwned. Поддерживаются два варианта:
+//   - один файл со строками "SHA1:COUNT", отсортированный по хэшу;
+//   - каталог с файлами по первым пяти символам хэша ("21BD1.txt"),
+//     в каждом строки "SUFFIX:COUNT" с оставшимися 35 символами, как в
+//     ответе range API (k-anonymity).
+//
+// Файлы не загружаются в память: строка ищется двоичным поиском по файлу
+type BreachedPasswords struct {
+	dir  string
+	file *os.File
+	size int64
+}
+
+// OpenBreachedPasswords открывает базу по пути к файлу или каталогу
+func OpenBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached passwords corpus: %w", err)
+	}
+	if info.IsDir() {
+		return &BreachedPasswords{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached passwords corpus: %w", err)
+	}
+	return &BreachedPasswords{file: f, size: info.Size()}, nil
+}
+
+// Close закрывает файл базы
+func (b *BreachedPasswords) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
+
+// Count сколько раз пароль встречался в утечках, 0 если ни разу
+func (b *BreachedPasswords) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if b.file != nil {
+		return searchCorpus(b.file, b.size, hash)
+	}
+
+	prefix, suffix := hash[:5], hash[5:]
+	f, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.Open(filepath.Join(b.dir, prefix))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// В неполной базе части префиксов может не быть
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open corpus bucket: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat corpus bucket: %w", err)
+	}
+	return searchCorpus(f, info.Size(), suffix)
+}
+
+// searchCorpus ищет хэш в отсортированном файле. Поиск идет по смещению в
+// байтах: смещению соответствует первая строка, начинающаяся не раньше него,
+// поэтому хэши строк растут вместе со смещением
+func searchCorpus(r io.ReaderAt, size int64, hash string) (int, error) {
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := corpusLineAt(r, size, mid)
+		if err != nil {
+			return 0, err
+		}
+		if line == nil || compareCorpusHash(line, hash) >= 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	line, err := corpusLineAt(r, size, lo)
+	if err != nil || line == nil || compareCorpusHash(line, hash) != 0 {
+		return 0, err
+	}
+
+	_, count, _ := bytes.Cut(line, []byte(":"))
+	n, err := strconv.Atoi(string(bytes.TrimSpace(count)))
+	if err != nil {
+		return 0, fmt.Errorf("malformed corpus line %q", line)
+	}
+	return n, nil
+}
+
+// corpusLineAt возвращает первую строку, начинающуюся не раньше offset,
+// или nil, если таких строк нет
+func corpusLineAt(r io.ReaderAt, size, offset int64) ([]byte, error) {
+	start := offset
+	if offset > 0 {
+		// Байт перед offset показывает, начинается ли строка ровно с него
+		start = offset - 1
+	}
+
+	buf := make([]byte, 2*maxCorpusLine)
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read corpus: %w", err)
+	}
+	buf = buf[:n]
+	atEOF := start+int64(n) >= size
+
+	if offset > 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			if atEOF {
+				return nil, nil
+			}
+			return nil, errors.New("malformed corpus: line too long")
+		}
+		buf = buf[i+1:]
+	}
+	if len(buf) == 0 {
+		return nil, nil
+	}
+
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	} else if !atEOF {
+		return nil, errors.New("malformed corpus: line too long")
+	}
+	return bytes.TrimRight(buf, "\r"), nil
+}
+
+func compareCorpusHash(line []byte, hash string) int {
+	lineHash, _, _ := bytes.Cut(line, []byte(":"))
+	return strings.Compare(strings.ToUpper(string(lineHash)), hash)
+}
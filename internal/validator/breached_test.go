@@ -0,0 +1,163 @@
+package validator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// corpus отсортированная база из трех хэшей с разрывами между ними
+const corpus = "0000000000000000000000000000000000000001:5\n" +
+	"7777777777777777777777777777777777777777:42\r\n" +
+	"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFE:3"
+
+func TestSearchCorpus(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		want int
+	}{
+		{"first entry", "0000000000000000000000000000000000000001", 5},
+		{"middle entry with CRLF", "7777777777777777777777777777777777777777", 42},
+		{"last entry without newline", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFE", 3},
+		{"before the first entry", "0000000000000000000000000000000000000000", 0},
+		{"between first and middle", "7777777777777777777777777777777777777776", 0},
+		{"between middle and last", "7777777777777777777777777777777777777778", 0},
+		{"after the last entry", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := strings.NewReader(corpus)
+			got, err := searchCorpus(r, r.Size(), tt.hash)
+			if err != nil {
+				t.Fatalf("searchCorpus(%s) error: %v", tt.hash, err)
+			}
+			if got != tt.want {
+				t.Errorf("searchCorpus(%s) = %d, want %d", tt.hash, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchCorpusSizes(t *testing.T) {
+	// Каждое число строк проверяет другую последовательность делений пополам
+	for size := 0; size <= 9; size++ {
+		var lines []string
+		for i := 0; i < size; i++ {
+			lines = append(lines, corpusHash(2*i+1)+":"+strconv.Itoa(i+1))
+		}
+		data := strings.Join(lines, "\n") + "\n"
+
+		for i := 0; i <= 2*size+1; i++ {
+			want := 0
+			if i%2 == 1 && i < 2*size {
+				want = (i-1)/2 + 1
+			}
+			r := strings.NewReader(data)
+			got, err := searchCorpus(r, r.Size(), corpusHash(i))
+			if err != nil {
+				t.Fatalf("size %d, hash %d: %v", size, i, err)
+			}
+			if got != want {
+				t.Errorf("size %d, hash %d: got %d, want %d", size, i, got, want)
+			}
+		}
+	}
+}
+
+func TestSearchCorpusLowercase(t *testing.T) {
+	r := strings.NewReader("abcdefabcdefabcdefabcdefabcdefabcdefabcd:7\n")
+	got, err := searchCorpus(r, r.Size(), "ABCDEFABCDEFABCDEFABCDEFABCDEFABCDEFABCD")
+	if err != nil || got != 7 {
+		t.Errorf("searchCorpus = %d, %v, want 7", got, err)
+	}
+}
+
+func TestSearchCorpusMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		corpus string
+		hash   string
+	}{
+		{"line too long", strings.Repeat("A", 3*maxCorpusLine) + ":1\n", "B"},
+		{"count not a number", "0000000000000000000000000000000000000001:x\n", "0000000000000000000000000000000000000001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := strings.NewReader(tt.corpus)
+			if _, err := searchCorpus(r, r.Size(), tt.hash); err == nil {
+				t.Error("searchCorpus error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestBreachedPasswordsCount(t *testing.T) {
+	hash := sha1Hex("password")
+	other := sha1Hex("correct horse battery staple")
+
+	t.Run("file", func(t *testing.T) {
+		lines := []string{hash + ":100", other + ":1"}
+		if other < hash {
+			lines[0], lines[1] = lines[1], lines[0]
+		}
+		path := filepath.Join(t.TempDir(), "pwned.txt")
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		breached := openBreached(t, path)
+		assertCount(t, breached, "password", 100)
+		assertCount(t, breached, "correct horse battery staple", 1)
+		assertCount(t, breached, "not in the corpus", 0)
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(hash[5:]+":100\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		breached := openBreached(t, dir)
+		assertCount(t, breached, "password", 100)
+		// Файла для префикса нет
+		assertCount(t, breached, "not in the corpus", 0)
+	})
+}
+
+func corpusHash(i int) string {
+	return fmt.Sprintf("%040X", i)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func openBreached(t *testing.T, path string) *BreachedPasswords {
+	t.Helper()
+	breached, err := OpenBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { breached.Close() })
+	return breached
+}
+
+func assertCount(t *testing.T, breached *BreachedPasswords, password string, want int) {
+	t.Helper()
+	got, err := breached.Count(password)
+	if err != nil {
+		t.Fatalf("Count(%q) error: %v", password, err)
+	}
+	if got != want {
+		t.Errorf("Count(%q) = %d, want %d", password, got, want)
+	}
+}
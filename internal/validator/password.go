@@ -0,0 +1,186 @@
+package validator
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"auth-user-service/internal/apierror"
+)
+
+// bcrypt учитывает только первые 72 байта пароля
+const bcryptMaxBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordPolicy требования к паролю
+type PasswordPolicy struct {
+	MinLength int // в символах
+	MaxLength int // в байтах, не больше 72
+	// MinClasses сколько из четырех классов символов (строчные, заглавные,
+	// цифры, спецсимволы) должно встречаться в пароле
+	MinClasses int
+	banned     map[string]bool
+}
+
+// PasswordViolation нарушенное правило политики
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError пароль не соответствует политике
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password " + strings.Join(messages, ", ")
+}
+
+// APIError ошибка валидации поля field: по элементу errors на каждое
+// нарушенное правило, код элемента совпадает с Rule
+func (e *PasswordPolicyError) APIError(field string) *apierror.Error {
+	fields := make([]apierror.FieldError, len(e.Violations))
+	for i, v := range e.Violations {
+		fields[i] = apierror.FieldError{Field: field, Code: v.Rule, Message: v.Message}
+	}
+	return apierror.Validation("password_policy", "Password does not meet the requirements", fields...)
+}
+
+// NewPasswordPolicy создает политику со встроенным списком распространенных
+// паролей. bannedFile дополняет его (по паролю в строке)
+func NewPasswordPolicy(minLength, maxLength, minClasses int, bannedFile string) (*PasswordPolicy, error) {
+	if maxLength <= 0 || maxLength > bcryptMaxBytes {
+		maxLength = bcryptMaxBytes
+	}
+	if minLength > maxLength {
+		return nil, fmt.Errorf("password min length %d exceeds max length %d", minLength, maxLength)
+	}
+
+	policy := &PasswordPolicy{
+		MinLength:  minLength,
+		MaxLength:  maxLength,
+		MinClasses: minClasses,
+		banned:     make(map[string]bool),
+	}
+	if err := policy.addBanned(strings.NewReader(commonPasswords)); err != nil {
+		return nil, err
+	}
+
+	if bannedFile != "" {
+		f, err := os.Open(bannedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open banned passwords file: %w", err)
+		}
+		defer f.Close()
+		if err := policy.addBanned(f); err != nil {
+			return nil, fmt.Errorf("failed to read banned passwords file: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *PasswordPolicy) addBanned(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.banned[strings.ToLower(line)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Validate проверяет пароль пользователя с указанным email и возвращает
+// *PasswordPolicyError со всеми нарушенными правилами
+func (p *PasswordPolicy) Validate(password, email string) error {
+	var violations []PasswordViolation
+
+	if length := len([]rune(password)); length < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("must be at least %d characters long", p.MinLength),
+		})
+	}
+	if len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("must be at most %d bytes long", p.MaxLength),
+		})
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		violations = append(violations, PasswordViolation{
+			Rule: "character_classes",
+			Message: fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols",
+				p.MinClasses),
+		})
+	}
+	if p.banned[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{
+			Rule:    "common_password",
+			Message: "is too common",
+		})
+	}
+	if containsEmail(password, email) {
+		violations = append(violations, PasswordViolation{
+			Rule:    "contains_email",
+			Message: "must not contain your email address",
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var hasUpper, hasLower, hasNumber, hasSpecial bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsNumber(char):
+			hasNumber = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char):
+			hasSpecial = true
+		}
+	}
+
+	count := 0
+	for _, has := range []bool{hasUpper, hasLower, hasNumber, hasSpecial} {
+		if has {
+			count++
+		}
+	}
+	return count
+}
+
+// containsEmail проверяет, не содержит ли пароль email или его локальную
+// часть (если она не слишком короткая, чтобы совпадение было случайным)
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= 3 && strings.Contains(password, local)
+}
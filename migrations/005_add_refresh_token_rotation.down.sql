@@ -0,0 +1,6 @@
+-- Remove refresh token rotation columns
+DROP INDEX IF EXISTS idx_auth_tokens_family_id;
+
+ALTER TABLE auth_tokens DROP COLUMN IF EXISTS revoked_at;
+ALTER TABLE auth_tokens DROP COLUMN IF EXISTS used_at;
+ALTER TABLE auth_tokens DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,9 @@
+-- Refresh token rotation: tokens are stored hashed and grouped into families
+DELETE FROM auth_tokens;
+
+ALTER TABLE auth_tokens ADD COLUMN family_id VARCHAR(64) NOT NULL;
+ALTER TABLE auth_tokens ADD COLUMN used_at TIMESTAMP;
+ALTER TABLE auth_tokens ADD COLUMN revoked_at TIMESTAMP;
+
+-- Index for family revocation
+CREATE INDEX idx_auth_tokens_family_id ON auth_tokens(family_id);
@@ -0,0 +1,3 @@
+-- Drop token revocation tables
+DROP TABLE IF EXISTS user_token_revocations CASCADE;
+DROP TABLE IF EXISTS revoked_tokens CASCADE;
@@ -0,0 +1,15 @@
+-- Revoked access tokens (used when Redis is not configured)
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- "Logout everywhere": tokens issued before revoked_before are rejected
+CREATE TABLE user_token_revocations (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    revoked_before TIMESTAMP NOT NULL
+);
+
+-- Index for cleanup of expired entries
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
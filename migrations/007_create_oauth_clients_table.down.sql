@@ -0,0 +1,5 @@
+-- Drop OAuth tables and the client binding of refresh tokens
+ALTER TABLE auth_tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE auth_tokens DROP COLUMN IF EXISTS client_id;
+DROP TABLE IF EXISTS oauth_authorization_codes CASCADE;
+DROP TABLE IF EXISTS oauth_clients CASCADE;
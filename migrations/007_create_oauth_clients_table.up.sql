@@ -0,0 +1,36 @@
+-- Registered OAuth 2.0 / OpenID Connect clients
+CREATE TABLE oauth_clients (
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(100) UNIQUE NOT NULL,
+    -- NULL for public clients (SPA, mobile), which must rely on PKCE alone
+    client_secret_hash VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Single-use authorization codes issued by /authorize
+CREATE TABLE oauth_authorization_codes (
+    id SERIAL PRIMARY KEY,
+    code_hash VARCHAR(64) UNIQUE NOT NULL,
+    client_id VARCHAR(100) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    nonce TEXT,
+    code_challenge VARCHAR(128) NOT NULL,
+    code_challenge_method VARCHAR(10) NOT NULL,
+    auth_time TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for authorization codes
+CREATE INDEX idx_oauth_codes_expires_at ON oauth_authorization_codes(expires_at);
+
+-- Refresh tokens issued through /token belong to the client and carry only
+-- the scopes the user granted it
+ALTER TABLE auth_tokens ADD COLUMN client_id VARCHAR(100) REFERENCES oauth_clients(client_id) ON DELETE CASCADE;
+ALTER TABLE auth_tokens ADD COLUMN scope TEXT NOT NULL DEFAULT '';
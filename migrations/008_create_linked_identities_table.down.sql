@@ -0,0 +1,3 @@
+-- Drop linked_identities table and case-insensitive email index
+DROP INDEX IF EXISTS idx_users_email_lower;
+DROP TABLE IF EXISTS linked_identities CASCADE;
@@ -0,0 +1,19 @@
+-- External identity provider accounts linked to users
+CREATE TABLE linked_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+-- Index for listing identities of a user
+CREATE INDEX idx_linked_identities_user_id ON linked_identities(user_id);
+
+-- Emails are compared case-insensitively, so Foo@example.com and
+-- foo@example.com must not belong to different accounts. Fails if such
+-- duplicates already exist; merge them before migrating
+CREATE UNIQUE INDEX idx_users_email_lower ON users (LOWER(email));
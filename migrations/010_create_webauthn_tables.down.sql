@@ -0,0 +1,3 @@
+-- Drop WebAuthn tables
+DROP TABLE IF EXISTS webauthn_challenges CASCADE;
+DROP TABLE IF EXISTS webauthn_credentials CASCADE;
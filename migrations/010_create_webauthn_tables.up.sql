@@ -0,0 +1,26 @@
+-- WebAuthn (passkey) credentials. public_key is the COSE_Key from the authenticator
+CREATE TABLE webauthn_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for listing a user's credentials
+CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- Pending registration and login challenges, used when Redis is not configured
+CREATE TABLE webauthn_challenges (
+    challenge VARCHAR(64) PRIMARY KEY,
+    ceremony VARCHAR(20) NOT NULL,
+    user_id INTEGER,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Index for cleaning up expired challenges
+CREATE INDEX idx_webauthn_challenges_expires_at ON webauthn_challenges(expires_at);
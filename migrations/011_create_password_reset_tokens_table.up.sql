@@ -0,0 +1,12 @@
+-- Single-use password reset tokens, stored hashed
+CREATE TABLE password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for invalidating a user's outstanding tokens
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
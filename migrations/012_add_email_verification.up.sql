@@ -0,0 +1,6 @@
+-- Email verification. Accounts that existed before verification was
+-- introduced are treated as verified
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN verification_sent_at TIMESTAMP;
+
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
@@ -0,0 +1,3 @@
+-- Remove account disabling
+DELETE FROM permissions WHERE name IN ('users:read', 'users:manage');
+ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
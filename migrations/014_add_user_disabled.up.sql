@@ -0,0 +1,11 @@
+-- Accounts disabled by an administrator cannot sign in
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;
+
+-- Permissions for the admin user management API
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'View users, their profiles and orders'),
+    ('users:manage', 'Disable accounts, force password resets and revoke sessions');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.name IN ('users:read', 'users:manage');
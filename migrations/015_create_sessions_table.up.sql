@@ -0,0 +1,19 @@
+-- A session is a refresh token family together with the device that opened it
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) UNIQUE NOT NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for listing a user's sessions
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+
+-- Existing token families become sessions without device metadata
+INSERT INTO sessions (user_id, family_id, created_at, last_used_at)
+SELECT user_id, family_id, MIN(created_at), MAX(created_at)
+FROM auth_tokens
+GROUP BY user_id, family_id;
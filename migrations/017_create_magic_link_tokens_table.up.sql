@@ -0,0 +1,13 @@
+-- Single-use passwordless login tokens, stored hashed. Tokens are bound to an
+-- email rather than a user so that a link can register a new account
+CREATE TABLE magic_link_tokens (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for invalidating outstanding tokens for an email
+CREATE INDEX idx_magic_link_tokens_email ON magic_link_tokens(email);
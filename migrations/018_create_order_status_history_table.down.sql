@@ -0,0 +1,3 @@
+-- Drop order status history
+DELETE FROM permissions WHERE name = 'orders:manage';
+DROP TABLE IF EXISTS order_status_history CASCADE;
@@ -0,0 +1,22 @@
+-- Audit trail of order status changes. from_status is NULL for the entry
+-- written when the order is created
+CREATE TABLE order_status_history (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for reading the history of an order
+CREATE INDEX idx_order_status_history_order_id ON order_status_history(order_id, created_at);
+
+-- Permission for the admin order API
+INSERT INTO permissions (name, description) VALUES
+    ('orders:manage', 'Move orders through their status lifecycle');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.name = 'orders:manage';
@@ -0,0 +1,2 @@
+-- Drop order items table
+DROP TABLE IF EXISTS order_items CASCADE;
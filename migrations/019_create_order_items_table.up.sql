@@ -0,0 +1,16 @@
+-- Line items of an order. discount is an absolute amount taken off the line,
+-- orders.price holds the total of all lines
+CREATE TABLE order_items (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    sku VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit_price DECIMAL(10,2) NOT NULL CHECK (unit_price >= 0),
+    discount DECIMAL(10,2) NOT NULL DEFAULT 0 CHECK (discount >= 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (discount <= quantity * unit_price)
+);
+
+-- Index for loading the items of an order
+CREATE INDEX idx_order_items_order_id ON order_items(order_id);
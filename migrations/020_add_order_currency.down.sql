@@ -0,0 +1,5 @@
+-- Remove order currency
+ALTER TABLE order_items ALTER COLUMN discount TYPE DECIMAL(10,2);
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DECIMAL(10,2);
+ALTER TABLE orders ALTER COLUMN price TYPE DECIMAL(10,2);
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,6 @@
+-- Orders carry an ISO 4217 currency; items share the currency of their order.
+-- Amounts get a third decimal place for currencies such as KWD
+ALTER TABLE orders ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE orders ALTER COLUMN price TYPE DECIMAL(15,3);
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DECIMAL(15,3);
+ALTER TABLE order_items ALTER COLUMN discount TYPE DECIMAL(15,3);
@@ -0,0 +1,3 @@
+-- Drop order keyset indexes
+DROP INDEX IF EXISTS idx_orders_user_price;
+DROP INDEX IF EXISTS idx_orders_user_created_at;
@@ -0,0 +1,3 @@
+-- Indexes for paging through a user's orders by (created_at, id) and (price, id)
+CREATE INDEX idx_orders_user_created_at ON orders(user_id, created_at DESC, id DESC);
+CREATE INDEX idx_orders_user_price ON orders(user_id, price, id);
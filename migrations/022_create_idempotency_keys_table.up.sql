@@ -0,0 +1,19 @@
+-- Results of requests sent with an Idempotency-Key, used when Redis is not
+-- configured. status_code is NULL while the first request is still running;
+-- lock_id identifies that request, so a request whose lock has expired cannot
+-- release a key reserved by the next one
+CREATE TABLE idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    lock_id VARCHAR(32) NOT NULL DEFAULT '',
+    status_code INTEGER,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    response_body BYTEA,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+
+-- Index for cleaning up expired keys
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);